@@ -0,0 +1,79 @@
+package pipe
+
+import "sync"
+
+// StripANSI returns data with terminal escape sequences removed: CSI
+// sequences such as colors and cursor movement, OSC sequences such as
+// window titles, and the other ESC-introduced sequences. Text and control
+// characters such as "\r" and "\n" are kept. An escape sequence cut off at
+// the end of data is dropped; to strip output that arrives in chunks, use
+// Config.StripANSI, which carries sequences over from one chunk to the next.
+func StripANSI(data []byte) []byte {
+	var st ansiStripper
+	return st.strip(data)
+}
+
+// ansiStripper removes escape sequences from a stream of output, keeping
+// track of a sequence that spans chunks.
+type ansiStripper struct {
+	mu    sync.Mutex
+	state parseState
+}
+
+// strip returns the text of data, not counting escape sequences, continuing
+// any sequence left open by the previous call.
+func (a *ansiStripper) strip(data []byte) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make([]byte, 0, len(data))
+	for _, b := range data {
+		if a.text(b) {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// text advances the parser by one byte and reports whether the byte is text
+// rather than part of an escape sequence. The caller must hold a.mu or
+// otherwise own a.
+func (a *ansiStripper) text(b byte) bool {
+	switch a.state {
+	case stateText:
+		if b == 0x1b {
+			a.state = stateEscape
+			return false
+		}
+		return true
+	case stateEscape:
+		switch b {
+		case '[':
+			a.state = stateCSI
+		case ']', 'P', 'X', '^', '_':
+			// OSC, DCS and the other string sequences run until
+			// BEL or ST
+			a.state = stateOSC
+		case '(', ')', '*', '+':
+			a.state = stateCharset
+		default:
+			a.state = stateText
+		}
+	case stateCharset:
+		a.state = stateText
+	case stateCSI:
+		if b >= 0x40 && b <= 0x7e {
+			a.state = stateText
+		}
+	case stateOSC:
+		switch b {
+		case 0x07:
+			a.state = stateText
+		case 0x1b:
+			a.state = stateOSCEscape
+		}
+	case stateOSCEscape:
+		a.state = stateText
+	}
+	return false
+}
@@ -0,0 +1,362 @@
+// Package ansihtml converts terminal output containing ANSI escape sequences
+// into HTML, turning SGR color and style codes into styled <span> elements.
+//
+// It is kept separate from package pipe so that programs that only need
+// process IO do not pay for it. A typical use is rendering a wrapped
+// command's colored output in a web dashboard:
+//
+//	html, err := ansihtml.RunToHTML(pipe.Config{Command: "ls", Args: []string{"--color=always"}})
+package ansihtml
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/liliang-cn/pipeit"
+)
+
+// RunToHTML runs the command described by cfg in a PTY, so that tools which
+// only color their output on a terminal still do so, and returns its output
+// converted to HTML. Any OnOutput handler in cfg is still called with the raw
+// output.
+func RunToHTML(cfg pipe.Config) (string, error) {
+	var buf bytes.Buffer
+	conv := NewConverter(&buf)
+
+	userHandler := cfg.OnOutput
+	cfg.OnOutput = func(data []byte) {
+		conv.Write(data)
+		if userHandler != nil {
+			userHandler(data)
+		}
+	}
+
+	pm := pipe.NewWithConfig(cfg)
+	if err := pm.StartWithPTY(); err != nil {
+		return "", err
+	}
+	err := pm.Wait()
+	pm.WaitForOutputDrain()
+	pm.Stop()
+
+	conv.Close()
+	return buf.String(), err
+}
+
+// Converter is an io.WriteCloser that converts a stream of terminal output
+// into HTML. Escape sequences may be split across calls to Write; incomplete
+// sequences are held back until the rest arrives.
+//
+// SGR sequences (colors, bold, italic, underline and so on) become <span>
+// elements with inline styles. All other escape sequences, such as cursor
+// movement and OSC titles, are dropped. Carriage returns are removed so that
+// PTY line endings ("\r\n") become plain newlines.
+type Converter struct {
+	w   io.Writer
+	mu  sync.Mutex
+	err error
+
+	state  parseState
+	params []byte
+
+	cur     style // style set by the most recent SGR sequence
+	open    style // style of the currently open <span>
+	hasOpen bool
+}
+
+type parseState int
+
+const (
+	stateText parseState = iota
+	stateEscape
+	stateCharset
+	stateCSI
+	stateOSC
+	stateOSCEscape
+)
+
+// style is the set of SGR attributes that affect rendering.
+type style struct {
+	fg, bg    string
+	bold      bool
+	dim       bool
+	italic    bool
+	underline bool
+	strike    bool
+	inverse   bool
+}
+
+// NewConverter returns a Converter that writes HTML to w.
+func NewConverter(w io.Writer) *Converter {
+	return &Converter{w: w}
+}
+
+// Write converts data and writes the resulting HTML. It returns the first
+// error encountered writing to the underlying writer.
+func (c *Converter) Write(data []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var text []byte
+	flush := func() {
+		if len(text) > 0 {
+			c.writeText(text)
+			text = text[:0]
+		}
+	}
+
+	for _, b := range data {
+		switch c.state {
+		case stateText:
+			switch b {
+			case 0x1b:
+				flush()
+				c.state = stateEscape
+			case '\r':
+			default:
+				text = append(text, b)
+			}
+		case stateEscape:
+			switch b {
+			case '[':
+				c.state = stateCSI
+				c.params = c.params[:0]
+			case ']', 'P', 'X', '^', '_':
+				// OSC, DCS and the other string sequences run until
+				// BEL or ST
+				c.state = stateOSC
+			case '(', ')', '*', '+':
+				// Charset designation such as ESC ( B, which takes one
+				// more byte
+				c.state = stateCharset
+			default:
+				// Two-byte escape such as ESC 7 or ESC =; drop it.
+				c.state = stateText
+			}
+		case stateCharset:
+			c.state = stateText
+		case stateCSI:
+			if b >= 0x40 && b <= 0x7e {
+				if b == 'm' {
+					c.applySGR(string(c.params))
+				}
+				c.state = stateText
+			} else {
+				c.params = append(c.params, b)
+			}
+		case stateOSC:
+			switch b {
+			case 0x07:
+				c.state = stateText
+			case 0x1b:
+				c.state = stateOSCEscape
+			}
+		case stateOSCEscape:
+			// ESC \ terminates the string.
+			c.state = stateText
+		}
+	}
+	flush()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	return len(data), nil
+}
+
+// Close closes any open <span>. It does not close the underlying writer.
+func (c *Converter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hasOpen {
+		c.write("</span>")
+		c.hasOpen = false
+	}
+	c.state = stateText
+	return c.err
+}
+
+// writeText emits text in the current style, opening or closing spans as
+// needed.
+func (c *Converter) writeText(text []byte) {
+	if !c.hasOpen || c.open != c.cur {
+		if c.hasOpen {
+			c.write("</span>")
+			c.hasOpen = false
+		}
+		if css := c.cur.css(); css != "" {
+			c.write(`<span style="` + css + `">`)
+			c.open = c.cur
+			c.hasOpen = true
+		}
+	}
+	c.write(html.EscapeString(string(text)))
+}
+
+func (c *Converter) write(s string) {
+	if c.err != nil {
+		return
+	}
+	_, c.err = io.WriteString(c.w, s)
+}
+
+// applySGR updates the current style from the parameters of an SGR sequence.
+func (c *Converter) applySGR(params string) {
+	if params == "" {
+		c.cur = style{}
+		return
+	}
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		n, err := strconv.Atoi(codes[i])
+		if err != nil {
+			continue
+		}
+		switch {
+		case n == 0:
+			c.cur = style{}
+		case n == 1:
+			c.cur.bold = true
+		case n == 2:
+			c.cur.dim = true
+		case n == 3:
+			c.cur.italic = true
+		case n == 4:
+			c.cur.underline = true
+		case n == 7:
+			c.cur.inverse = true
+		case n == 9:
+			c.cur.strike = true
+		case n == 22:
+			c.cur.bold, c.cur.dim = false, false
+		case n == 23:
+			c.cur.italic = false
+		case n == 24:
+			c.cur.underline = false
+		case n == 27:
+			c.cur.inverse = false
+		case n == 29:
+			c.cur.strike = false
+		case n >= 30 && n <= 37:
+			c.cur.fg = palette[n-30]
+		case n == 38:
+			color, used := extendedColor(codes[i+1:])
+			c.cur.fg = color
+			i += used
+		case n == 39:
+			c.cur.fg = ""
+		case n >= 40 && n <= 47:
+			c.cur.bg = palette[n-40]
+		case n == 48:
+			color, used := extendedColor(codes[i+1:])
+			c.cur.bg = color
+			i += used
+		case n == 49:
+			c.cur.bg = ""
+		case n >= 90 && n <= 97:
+			c.cur.fg = palette[n-90+8]
+		case n >= 100 && n <= 107:
+			c.cur.bg = palette[n-100+8]
+		}
+	}
+}
+
+// extendedColor parses the arguments of a 38 or 48 SGR code ("5;n" or
+// "2;r;g;b") and reports how many of them it consumed.
+func extendedColor(args []string) (string, int) {
+	if len(args) == 0 {
+		return "", 0
+	}
+	switch args[0] {
+	case "5":
+		if len(args) < 2 {
+			return "", len(args)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 || n > 255 {
+			return "", 2
+		}
+		return color256(n), 2
+	case "2":
+		if len(args) < 4 {
+			return "", len(args)
+		}
+		var rgb [3]int
+		for i := range rgb {
+			v, err := strconv.Atoi(args[i+1])
+			if err != nil || v < 0 || v > 255 {
+				return "", 4
+			}
+			rgb[i] = v
+		}
+		return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2]), 4
+	}
+	return "", 1
+}
+
+// palette holds the 16 standard xterm colors.
+var palette = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// color256 returns the xterm 256-color palette entry n.
+func color256(n int) string {
+	switch {
+	case n < 16:
+		return palette[n]
+	case n < 232:
+		n -= 16
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6], levels[n%6])
+	default:
+		v := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", v, v, v)
+	}
+}
+
+// css renders the style as an inline CSS declaration list.
+func (s style) css() string {
+	fg, bg := s.fg, s.bg
+	if s.inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = palette[0]
+		}
+		if bg == "" {
+			bg = palette[7]
+		}
+	}
+
+	var decls []string
+	if fg != "" {
+		decls = append(decls, "color:"+fg)
+	}
+	if bg != "" {
+		decls = append(decls, "background-color:"+bg)
+	}
+	if s.bold {
+		decls = append(decls, "font-weight:bold")
+	}
+	if s.dim {
+		decls = append(decls, "opacity:0.7")
+	}
+	if s.italic {
+		decls = append(decls, "font-style:italic")
+	}
+	switch {
+	case s.underline && s.strike:
+		decls = append(decls, "text-decoration:underline line-through")
+	case s.underline:
+		decls = append(decls, "text-decoration:underline")
+	case s.strike:
+		decls = append(decls, "text-decoration:line-through")
+	}
+	return strings.Join(decls, ";")
+}
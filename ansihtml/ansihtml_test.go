@@ -0,0 +1,40 @@
+package ansihtml
+
+import (
+	"bytes"
+	"testing"
+)
+
+// convert runs data through a Converter one chunk at a time.
+func convert(chunks ...string) string {
+	var buf bytes.Buffer
+	c := NewConverter(&buf)
+	for _, chunk := range chunks {
+		c.Write([]byte(chunk))
+	}
+	c.Close()
+	return buf.String()
+}
+
+func TestConverterCharsetDesignator(t *testing.T) {
+	// tput sgr0 emits ESC ( B before the SGR reset
+	got := convert("\x1b[1mbold\x1b(B\x1b[m plain")
+	want := `<span style="font-weight:bold">bold</span> plain`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestConverterCharsetDesignatorSplit(t *testing.T) {
+	got := convert("a\x1b", "(", "Bb")
+	if got != "ab" {
+		t.Errorf("got %q, want %q", got, "ab")
+	}
+}
+
+func TestConverterStringSequences(t *testing.T) {
+	got := convert("\x1b]0;title\x07a\x1bPdcs\x1b\\b")
+	if got != "ab" {
+		t.Errorf("got %q, want %q", got, "ab")
+	}
+}
@@ -0,0 +1,41 @@
+package pipe
+
+// handlerCall is a chunk of output waiting to be passed to the handlers for
+// its stream.
+type handlerCall struct {
+	s    Stream
+	data []byte
+}
+
+// startHandlerQueueLocked starts the goroutine that calls the handlers for a
+// new session when AsyncHandlers is set. The caller must hold p.mu.
+func (p *ProcessManager) startHandlerQueueLocked() {
+	if p.asyncBufferSize <= 0 {
+		return
+	}
+	queue := make(chan handlerCall, p.asyncBufferSize)
+	done := make(chan struct{})
+	p.handlerQueue, p.handlersDone = queue, done
+
+	go func() {
+		defer close(done)
+		for call := range queue {
+			p.handle(call.s, call.data)
+		}
+	}()
+}
+
+// stopHandlerQueue closes the session's handler queue once every read loop
+// has finished and waits for the queued chunks to be handled.
+func (p *ProcessManager) stopHandlerQueue() {
+	p.mu.Lock()
+	queue, done := p.handlerQueue, p.handlersDone
+	p.handlerQueue, p.handlersDone = nil, nil
+	p.mu.Unlock()
+
+	if queue == nil {
+		return
+	}
+	close(queue)
+	<-done
+}
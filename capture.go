@@ -0,0 +1,90 @@
+package pipe
+
+import (
+	"bytes"
+	"io"
+)
+
+// CaptureTo appends all stdout output (all output in PTY mode) to buf. It is
+// a race-free alternative to collecting output in a handler closure: writes
+// from both read goroutines are serialized by the ProcessManager, including
+// when the same buffer is also passed to CaptureStderrTo.
+//
+// The buffer must not be read until output has finished, that is after Wait
+// followed by WaitForOutputDrain.
+func (p *ProcessManager) CaptureTo(buf *bytes.Buffer) {
+	p.addCapture(StdOut, buf)
+}
+
+// CaptureStderrTo appends all stderr output to buf, with the same guarantees
+// as CaptureTo. In PTY mode stderr is merged into stdout, so it captures
+// nothing.
+func (p *ProcessManager) CaptureStderrTo(buf *bytes.Buffer) {
+	p.addCapture(StdErr, buf)
+}
+
+func (p *ProcessManager) addCapture(s Stream, buf *bytes.Buffer) {
+	p.captureMu.Lock()
+	defer p.captureMu.Unlock()
+	p.captures[s] = append(p.captures[s], buf)
+}
+
+// SetOutputWriter mirrors all stdout output (all output in PTY mode) to w,
+// replacing any writer set before; nil stops mirroring. Writes happen on the
+// read loop, in order and never concurrently with each other or with writes
+// to the error writer, so w need not be safe for concurrent use. A slow w
+// slows down reading. Write errors are passed to Config.OnWriterError, and
+// later output is still written to w.
+func (p *ProcessManager) SetOutputWriter(w io.Writer) {
+	p.captureMu.Lock()
+	defer p.captureMu.Unlock()
+	p.writers[StdOut] = w
+}
+
+// SetErrorWriter is like SetOutputWriter for stderr output. In PTY mode
+// stderr is merged into stdout, so nothing is written to w.
+func (p *ProcessManager) SetErrorWriter(w io.Writer) {
+	p.captureMu.Lock()
+	defer p.captureMu.Unlock()
+	p.writers[StdErr] = w
+}
+
+// capture appends a chunk of output to the buffers registered for s and
+// writes it to the stream's writer, collapsing progress updates first if
+// Config.CollapseProgress is set.
+func (p *ProcessManager) capture(s Stream, data []byte) {
+	p.captureMu.Lock()
+	var errs []error
+	if c := p.collapsers[s]; c != nil {
+		c.Write(data)
+		errs, p.collapseErrs = p.collapseErrs, nil
+	} else if err := p.captureLocked(s, data); err != nil {
+		errs = append(errs, err)
+	}
+	p.captureMu.Unlock()
+
+	p.writerErrors(errs)
+}
+
+// captureLocked appends data to the buffers registered for s and writes it
+// to the stream's writer. The caller must hold p.captureMu.
+func (p *ProcessManager) captureLocked(s Stream, data []byte) error {
+	for _, buf := range p.captures[s] {
+		buf.Write(data)
+	}
+	if w := p.writers[s]; w != nil {
+		_, err := w.Write(data)
+		return err
+	}
+	return nil
+}
+
+// writerErrors passes errs to Config.OnWriterError, if set.
+func (p *ProcessManager) writerErrors(errs []error) {
+	if p.onWriterError == nil {
+		return
+	}
+	for _, err := range errs {
+		p.onWriterError(err)
+	}
+}
@@ -0,0 +1,182 @@
+package pipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// castRecorder writes output as an asciinema v2 cast.
+type castRecorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	start   time.Time
+	pending []byte // incomplete UTF-8 sequence held back from the last chunk
+	err     error
+}
+
+// castHeader is the first line of an asciinema v2 cast.
+type castHeader struct {
+	Version   int   `json:"version"`
+	Width     int   `json:"width"`
+	Height    int   `json:"height"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// StartRecording records the process's output to w as an asciinema v2 cast
+// (https://docs.asciinema.org/manual/asciicast/v2/), which can be played
+// back with "asciinema play". The header takes its size from SetWindowSize,
+// or 80x24 if none has been set, and later calls to SetWindowSize are
+// recorded as resize events. Event times are relative to the call to
+// StartRecording.
+//
+// Recording ends when the process's output ends or StopRecording is called.
+// Output from both streams is recorded; input is not.
+func (p *ProcessManager) StartRecording(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cast != nil {
+		return fmt.Errorf("already recording")
+	}
+
+	width, height := defaultScreenCols, defaultScreenRows
+	if p.winsize != nil {
+		width, height = int(p.winsize.Cols), int(p.winsize.Rows)
+	}
+	rec := &castRecorder{w: w, start: time.Now()}
+	header, err := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: rec.start.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		return err
+	}
+	p.cast = rec
+	return nil
+}
+
+// StopRecording ends a recording started with StartRecording and returns
+// the first error writing it, if any. If the recording already ended with
+// the process's output, it returns that recording's error.
+func (p *ProcessManager) StopRecording() error {
+	p.endRecording()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.castErr
+	p.castErr = nil
+	return err
+}
+
+// endRecording finishes the recording, if there is one, keeping its error
+// for StopRecording.
+func (p *ProcessManager) endRecording() {
+	p.mu.Lock()
+	rec := p.cast
+	p.cast = nil
+	p.mu.Unlock()
+
+	if rec == nil {
+		return
+	}
+	err := rec.close()
+
+	p.mu.Lock()
+	p.castErr = err
+	p.mu.Unlock()
+}
+
+// recordCast adds a chunk of output to the recording, if there is one.
+func (p *ProcessManager) recordCast(data []byte) {
+	p.mu.Lock()
+	rec := p.cast
+	p.mu.Unlock()
+
+	if rec != nil {
+		rec.output(data)
+	}
+}
+
+// recordResizeLocked adds the current window size to the recording, if
+// there is one. The caller must hold p.mu.
+func (p *ProcessManager) recordResizeLocked() {
+	if p.cast != nil && p.winsize != nil {
+		p.cast.event("r", fmt.Sprintf("%dx%d", p.winsize.Cols, p.winsize.Rows))
+	}
+}
+
+// output records data as an output event. A multi-byte character split
+// across chunks is held back until the rest of it arrives, as the cast
+// format requires valid UTF-8.
+func (r *castRecorder) output(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) > 0 {
+		data = append(r.pending, data...)
+		r.pending = nil
+	}
+	if i := incompleteRune(data); i < len(data) {
+		r.pending = append([]byte(nil), data[i:]...)
+		data = data[:i]
+	}
+	if len(data) > 0 {
+		r.eventLocked("o", string(data))
+	}
+}
+
+func (r *castRecorder) event(code, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.eventLocked(code, data)
+}
+
+// eventLocked writes one event line. After a write error nothing more is
+// written. The caller must hold r.mu.
+func (r *castRecorder) eventLocked(code, data string) {
+	if r.err != nil {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]any{elapsed, code, data})
+	if err != nil {
+		r.err = err
+		return
+	}
+	_, r.err = r.w.Write(append(line, '\n'))
+}
+
+// close writes out any held-back bytes and returns the first write error.
+func (r *castRecorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) > 0 {
+		r.eventLocked("o", string(r.pending))
+		r.pending = nil
+	}
+	return r.err
+}
+
+// incompleteRune returns the offset of a UTF-8 sequence cut off at the end
+// of data, or len(data) if there is none.
+func incompleteRune(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
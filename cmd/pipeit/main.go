@@ -1,19 +1,25 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
-	"os/signal"
 	"syscall"
 
 	"github.com/liliang-cn/pipeit"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the command and returns the status to exit with. It is separate
+// from main so that deferred cleanup, such as restoring the terminal, runs
+// before the program exits.
+func run() int {
 	if len(os.Args) < 2 {
 		fmt.Printf("Usage: %s <command> [args...]\n", os.Args[0])
-		os.Exit(1)
+		return 1
 	}
 
 	command := os.Args[1]
@@ -22,65 +28,34 @@ func main() {
 	// Create a new process manager
 	pm := pipe.New(command, args...)
 
-	// Handle output
-	pm.SetOutputHandler(func(data []byte) {
-		os.Stdout.Write(data)
-	})
-
-	// Handle error (if separated, though PTY merges them usually)
-	pm.SetErrorHandler(func(data []byte) {
-		os.Stderr.Write(data)
-	})
-
 	// Start the process with PTY
 	if err := pm.StartWithPTY(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error starting command: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 	defer pm.Stop()
 
-	// Forward Stdin to the process
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := os.Stdin.Read(buf)
-			if n > 0 {
-				_, wErr := pm.Write(buf[:n])
-				if wErr != nil {
-					return
-				}
-			}
-			if err != nil {
-				if err != io.EOF {
-					// fmt.Fprintf(os.Stderr, "Stdin read error: %v\n", err)
-				}
-				return
-			}
-		}
-	}()
+	// Pass interrupts and termination requests through to the child
+	stopForwarding, err := pm.ForwardSignals(syscall.SIGINT, syscall.SIGTERM)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error forwarding signals: %v\n", err)
+		return 1
+	}
+	defer stopForwarding()
 
-	// Handle signals (pass interrupt to child if possible, or just exit)
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		for sig := range sigChan {
-			// ideally we send this to the child process
-			// pipe package might need a Signal method, but for now we stop.
-			if sig == syscall.SIGINT {
-				// User hit Ctrl+C, usually PTY handles this if we were in raw mode,
-				// but since we are not in raw mode, our shell catches it.
-				// For now, let's just stop the process manager.
-				pm.Stop()
-				os.Exit(0)
-			}
-		}
-	}()
+	// Connect our terminal to the child until it exits. Bridge puts the
+	// terminal into raw mode, so keystrokes such as Ctrl+C and the arrow
+	// keys reach the child untouched, and keeps the child's terminal the
+	// same size as ours.
+	err = pm.Bridge(os.Stdin, os.Stdout)
 
-	// Wait for the process to finish
-	if err := pm.Wait(); err != nil {
-		// Verify if it's just an exit code error
-		if exitErr, ok := err.(*os.PathError); ok {
-			fmt.Fprintf(os.Stderr, "Command failed: %v\n", exitErr)
-		}
+	var perr *pipe.ProcessError
+	if errors.As(err, &perr) && !perr.Signaled {
+		return perr.ExitCode
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Command failed: %v\n", err)
+		return 1
 	}
+	return 0
 }
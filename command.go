@@ -0,0 +1,76 @@
+package pipe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BuildCommand expands a command template into a command name and argument
+// list suitable for Config.Command and Config.Args.
+//
+// The template is split into words on whitespace, then each {name}
+// placeholder is replaced with vars[name]. A substituted value always stays
+// inside the word it appears in, so values containing spaces, quotes or shell
+// metacharacters can never inject extra arguments. Use {{ and }} for literal
+// braces.
+//
+// BuildCommand does not perform shell parsing: there is no quoting, globbing
+// or variable expansion, and the result is never passed to a shell.
+//
+//	cmd, args, err := pipe.BuildCommand("git -C {dir} log --author={who}", map[string]string{
+//		"dir": "/src/my repo",
+//		"who": "Jane Doe",
+//	})
+//	// cmd == "git", args == []string{"-C", "/src/my repo", "log", "--author=Jane Doe"}
+func BuildCommand(template string, vars map[string]string) (string, []string, error) {
+	words := strings.Fields(template)
+	if len(words) == 0 {
+		return "", nil, fmt.Errorf("build command: empty template")
+	}
+
+	argv := make([]string, 0, len(words))
+	for _, word := range words {
+		expanded, err := expandWord(word, vars)
+		if err != nil {
+			return "", nil, fmt.Errorf("build command: %w", err)
+		}
+		argv = append(argv, expanded)
+	}
+	if argv[0] == "" {
+		return "", nil, fmt.Errorf("build command: command name is empty")
+	}
+	return argv[0], argv[1:], nil
+}
+
+// expandWord replaces the placeholders in a single template word.
+func expandWord(word string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case c == '{' && i+1 < len(word) && word[i+1] == '{':
+			b.WriteByte('{')
+			i++
+		case c == '}' && i+1 < len(word) && word[i+1] == '}':
+			b.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(word[i+1:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated placeholder in %q", word)
+			}
+			name := word[i+1 : i+1+end]
+			value, ok := vars[name]
+			if !ok {
+				return "", fmt.Errorf("undefined variable %q", name)
+			}
+			b.WriteString(value)
+			i += end + 1
+		case c == '}':
+			return "", fmt.Errorf("unexpected '}' in %q", word)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,80 @@
+package pipe
+
+import "sync"
+
+// echoMaxPending is the most input kept waiting for its echo. Older input
+// is forgotten first.
+const echoMaxPending = 4096
+
+// echoFilter removes the terminal's echo of written input from PTY output,
+// for Config.SuppressEcho.
+type echoFilter struct {
+	mu      sync.Mutex
+	pending []byte // input, as the terminal echoes it, not yet seen
+	esc     ansiStripper
+}
+
+// expect adds data, just written to the terminal, to the echo to look for.
+// Control characters other than tab and line ends are echoed in caret
+// notation, as "^C".
+func (e *echoFilter) expect(data []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, b := range data {
+		switch {
+		case b == '\t' || b == '\r' || b == '\n':
+			e.pending = append(e.pending, b)
+		case b < 0x20:
+			e.pending = append(e.pending, '^', b+'@')
+		default:
+			e.pending = append(e.pending, b)
+		}
+	}
+	if over := len(e.pending) - echoMaxPending; over > 0 {
+		e.pending = append(e.pending[:0], e.pending[over:]...)
+	}
+}
+
+// filter returns data without the echo at its start. Escape sequences and
+// carriage returns are dropped while the echo is being matched, and an
+// input line end is matched by the newline of the "\r\n" it is echoed as.
+func (e *echoFilter) filter(data []byte) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, b := range data {
+		if len(e.pending) == 0 {
+			return data[i:]
+		}
+		if !e.esc.text(b) {
+			continue
+		}
+		want := e.pending[0]
+		switch {
+		case want == '\r' || want == '\n':
+			if b == '\n' {
+				e.pending = e.pending[1:]
+			} else if b != '\r' {
+				e.pending = nil
+				return data[i:]
+			}
+		case b == want:
+			e.pending = e.pending[1:]
+		case b == '\r':
+			// a line editor moving back to redraw or wrap the line
+		default:
+			e.pending = nil
+			return data[i:]
+		}
+	}
+	return nil
+}
+
+// reset forgets any input still waiting for its echo.
+func (e *echoFilter) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending = nil
+	e.esc.state = stateText
+}
@@ -0,0 +1,180 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// PrependPath adds dir to the front of the child's PATH, so executables in
+// dir take precedence over same-named ones later in PATH. It must be called
+// before the process is started, and dir must be an absolute path.
+//
+// The command itself is resolved again against the new PATH, so a bare
+// command name such as "tool" picks up dir/tool if it exists. As with
+// exec.LookPath, relative entries already in PATH are not searched.
+func (p *ProcessManager) PrependPath(dir string) error {
+	if err := checkPathDirs([]string{dir}); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dirs := []string{dir}
+	if current := p.pathLocked(); current != "" {
+		dirs = append(dirs, current)
+	}
+	return p.setPathLocked(strings.Join(dirs, string(os.PathListSeparator)))
+}
+
+// SetPath replaces the child's PATH with dirs, searched in order. It must be
+// called before the process is started, and dirs must be absolute paths. As
+// with PrependPath, the command is resolved again against the new PATH.
+func (p *ProcessManager) SetPath(dirs []string) error {
+	if err := checkPathDirs(dirs); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.setPathLocked(strings.Join(dirs, string(os.PathListSeparator)))
+}
+
+// SetEnv replaces the child's environment with env, a list of "key=value"
+// entries. It must be called before the process is started.
+func (p *ProcessManager) SetEnv(env []string) error {
+	if err := checkEnv(env); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done != nil {
+		return fmt.Errorf("cannot change the environment after the process has started")
+	}
+	p.cmd.Env = append([]string(nil), env...)
+	return nil
+}
+
+// AppendEnv adds "key=value" entries to the child's environment, such as
+// AppendEnv("TERM=xterm-256color"). Entries override earlier ones with the
+// same key. It must be called before the process is started.
+func (p *ProcessManager) AppendEnv(kv ...string) error {
+	if err := checkEnv(kv); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done != nil {
+		return fmt.Errorf("cannot change the environment after the process has started")
+	}
+	if p.cmd.Env == nil {
+		p.cmd.Env = os.Environ()
+	}
+	p.cmd.Env = append(p.cmd.Env, kv...)
+	return nil
+}
+
+// checkPathDirs reports an error for any directory that is not absolute,
+// since resolving the command in it would depend on the working directory.
+func checkPathDirs(dirs []string) error {
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("PATH entry %q is not an absolute path", dir)
+		}
+	}
+	return nil
+}
+
+// checkEnv reports an error for any entry that is not of the form
+// "key=value".
+func checkEnv(env []string) error {
+	for _, kv := range env {
+		if !strings.Contains(kv, "=") {
+			return fmt.Errorf("invalid environment entry %q: missing '='", kv)
+		}
+	}
+	return nil
+}
+
+// SetDir sets the working directory the process is started in. It must be
+// called before the process is started, and dir must be an existing
+// directory.
+func (p *ProcessManager) SetDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("set working directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("set working directory: %s is not a directory", dir)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done != nil {
+		return fmt.Errorf("cannot change working directory after the process has started")
+	}
+	p.cmd.Dir = dir
+	return nil
+}
+
+// pathLocked returns the PATH the child would currently receive.
+// The caller must hold p.mu.
+func (p *ProcessManager) pathLocked() string {
+	if p.cmd.Env == nil {
+		return os.Getenv("PATH")
+	}
+	// As with exec.Cmd, the last entry for a key wins.
+	for i := len(p.cmd.Env) - 1; i >= 0; i-- {
+		if value, ok := strings.CutPrefix(p.cmd.Env[i], "PATH="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// setPathLocked replaces the PATH in the child's environment and resolves
+// the command against it. The caller must hold p.mu.
+func (p *ProcessManager) setPathLocked(path string) error {
+	if p.done != nil {
+		return fmt.Errorf("cannot change PATH after the process has started")
+	}
+
+	env := p.cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	filtered := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, "PATH=") {
+			filtered = append(filtered, kv)
+		}
+	}
+	p.cmd.Env = append(filtered, "PATH="+path)
+
+	name := p.cmd.Args[0]
+	if strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, '/') {
+		return nil
+	}
+	p.cmd.Path = name
+	p.cmd.Err = &exec.Error{Name: name, Err: exec.ErrNotFound}
+	for _, dir := range filepath.SplitList(path) {
+		// exec.LookPath refuses matches in relative entries with ErrDot.
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		if found, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			p.cmd.Path = found
+			p.cmd.Err = nil
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package pipe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathRejectsRelativeDirs(t *testing.T) {
+	pm := New("tool")
+	for _, dir := range []string{"", ".", "bin", filepath.Join("..", "bin")} {
+		if err := pm.PrependPath(dir); err == nil {
+			t.Errorf("PrependPath(%q) succeeded, want an error", dir)
+		}
+		if err := pm.SetPath([]string{"/usr/bin", dir}); err == nil {
+			t.Errorf("SetPath with %q succeeded, want an error", dir)
+		}
+	}
+}
+
+func TestPrependPathSkipsRelativeEntries(t *testing.T) {
+	// A "tool" in the working directory, reachable only through a relative
+	// entry inherited from PATH.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Mkdir("bin", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("bin", "tool"), []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", "bin")
+
+	pm := New("tool")
+	if err := pm.PrependPath(t.TempDir()); err != nil {
+		t.Fatalf("PrependPath: %v", err)
+	}
+	if cmd := pm.Cmd(); cmd.Err == nil {
+		t.Errorf("command resolved to %q through a relative PATH entry", cmd.Path)
+	}
+}
@@ -0,0 +1,102 @@
+package pipe
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// outputEvent is one line written to the event writer.
+type outputEvent struct {
+	Time     time.Time `json:"ts"`
+	Stream   string    `json:"stream"`
+	Data     string    `json:"data"`
+	Encoding string    `json:"encoding,omitempty"`
+}
+
+// SetEventWriter writes each chunk of output from either stream to w as a
+// JSON object on a line of its own, for feeding structured log pipelines:
+//
+//	{"ts":"2024-05-01T12:00:00.123456789Z","stream":"stdout","data":"hello\r\n"}
+//
+// Text is JSON-escaped, so control characters such as escape sequences are
+// kept safely. A chunk that is not valid UTF-8 is base64-encoded instead,
+// and its event carries "encoding":"base64". A multi-byte character split
+// across reads is held back until the rest of it arrives.
+//
+// It replaces any writer set before; nil stops writing events. Writes are
+// serialized with those to the output writers, so w need not be safe for
+// concurrent use, and errors are passed to Config.OnWriterError.
+func (p *ProcessManager) SetEventWriter(w io.Writer) {
+	p.captureMu.Lock()
+	defer p.captureMu.Unlock()
+	p.eventWriter = w
+	p.eventPending = [2][]byte{}
+}
+
+// writeEvent writes a chunk of output from s to the event writer, if set.
+func (p *ProcessManager) writeEvent(s Stream, data []byte) {
+	p.captureMu.Lock()
+	var err error
+	if p.eventWriter != nil {
+		if pending := p.eventPending[s]; len(pending) > 0 {
+			data = append(pending, data...)
+			p.eventPending[s] = nil
+		}
+		if i := incompleteRune(data); i < len(data) {
+			p.eventPending[s] = append([]byte(nil), data[i:]...)
+			data = data[:i]
+		}
+		err = p.writeEventLocked(s, data)
+	}
+	p.captureMu.Unlock()
+
+	if err != nil && p.onWriterError != nil {
+		p.onWriterError(err)
+	}
+}
+
+// flushEvents writes out any bytes held back from the event writer, once
+// the output has ended.
+func (p *ProcessManager) flushEvents() {
+	p.captureMu.Lock()
+	var errs []error
+	for s, pending := range p.eventPending {
+		p.eventPending[s] = nil
+		if p.eventWriter != nil {
+			if err := p.writeEventLocked(Stream(s), pending); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	p.captureMu.Unlock()
+
+	if p.onWriterError != nil {
+		for _, err := range errs {
+			p.onWriterError(err)
+		}
+	}
+}
+
+// writeEventLocked encodes one event for data, if any. The caller must hold
+// p.captureMu.
+func (p *ProcessManager) writeEventLocked(s Stream, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	ev := outputEvent{Time: time.Now(), Stream: s.String()}
+	if utf8.Valid(data) {
+		ev.Data = string(data)
+	} else {
+		ev.Data = base64.StdEncoding.EncodeToString(data)
+		ev.Encoding = "base64"
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = p.eventWriter.Write(append(line, '\n'))
+	return err
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/liliang-cn/pipeit"
@@ -11,16 +11,25 @@ import (
 func main() {
 	fmt.Println("Starting Claude via pipeit...")
 
-	// Create a new process manager for 'claude' using Config to set Env
+	// Terminal size - CRITICAL for interactive menus, which read it at
+	// startup. Match ours, or use 24x80 when output is not a terminal.
+	rows, cols, err := pipe.TerminalSize()
+	if err != nil {
+		rows, cols = 24, 80
+	}
+
+	// Create a new process manager for 'claude'
 	config := pipe.Config{
 		Command: "claude",
-		Env:     append(os.Environ(), "TERM=xterm-256color"),
+		Rows:    rows,
+		Cols:    cols,
 		OnOutput: func(data []byte) {
 			fmt.Print(string(data))
 		},
 	}
 
 	pm := pipe.NewWithConfig(config)
+	pm.AppendEnv("TERM=xterm-256color")
 
 	// Start the process with a PTY for interactive behavior
 	if err := pm.StartWithPTY(); err != nil {
@@ -28,9 +37,6 @@ func main() {
 	}
 	defer pm.Stop()
 
-	// Set terminal size - CRITICAL for interactive menus
-	pm.SetWindowSize(24, 80)
-
 	// Wait for initialization
 	time.Sleep(3 * time.Second)
 
@@ -44,10 +50,12 @@ func main() {
 	// Send a simple prompt
 	fmt.Println("\n[PIPEIT]: Sending prompt...")
 	pm.Writeln("Briefly tell me who you are.")
-	
+
 	// Wait longer for response generation
 	fmt.Println("[PIPEIT]: Waiting for response (45s)...")
-	time.Sleep(45 * time.Second)
+	if err := pm.WaitWithTimeout(45 * time.Second); err != nil && !errors.Is(err, pipe.ErrWaitTimeout) {
+		fmt.Printf("\n[PIPEIT]: claude exited: %v\n", err)
+	}
 
 	fmt.Println("\n[PIPEIT]: Stopping...")
 }
@@ -55,12 +55,13 @@ func runBash() {
 	}
 	defer pm.Stop()
 
-	// Send command
-	pm.Writeln("echo 'Hello from bash!'")
-	time.Sleep(300 * time.Millisecond)
+	// Wait for the prompt instead of sleeping, so each command is only
+	// sent once the previous one has finished.
+	pm.Expect("$ ", 5*time.Second)
 
-	pm.Writeln("pwd")
-	time.Sleep(300 * time.Millisecond)
+	// Send command
+	pm.WriteAndExpect("echo 'Hello from bash!'", "$ ", 5*time.Second)
+	pm.WriteAndExpect("pwd", "$ ", 5*time.Second)
 
 	pm.Writeln("exit")
 	pm.Wait()
@@ -82,11 +83,10 @@ func runZsh() {
 	}
 	defer pm.Stop()
 
-	pm.Writeln("echo 'Hello from zsh!'")
-	time.Sleep(300 * time.Millisecond)
+	pm.Expect("% ", 5*time.Second)
 
-	pm.Writeln("which zsh")
-	time.Sleep(300 * time.Millisecond)
+	pm.WriteAndExpect("echo 'Hello from zsh!'", "% ", 5*time.Second)
+	pm.WriteAndExpect("which zsh", "% ", 5*time.Second)
 
 	pm.Writeln("exit")
 	pm.Wait()
@@ -108,14 +108,11 @@ func runPython() {
 	}
 	defer pm.Stop()
 
-	pm.Writeln("print('Hello from Python!')")
-	time.Sleep(300 * time.Millisecond)
+	pm.Expect(">>> ", 5*time.Second)
 
-	pm.Writeln("import sys")
-	time.Sleep(200 * time.Millisecond)
-
-	pm.Writeln("print(sys.version)")
-	time.Sleep(300 * time.Millisecond)
+	pm.WriteAndExpect("print('Hello from Python!')", ">>> ", 5*time.Second)
+	pm.WriteAndExpect("import sys", ">>> ", 5*time.Second)
+	pm.WriteAndExpect("print(sys.version)", ">>> ", 5*time.Second)
 
 	pm.Writeln("exit()")
 	pm.Wait()
@@ -141,9 +138,10 @@ func runStream() {
 	}
 	defer pm.Stop()
 
+	pm.Expect("$ ", 5*time.Second)
+
 	// Run loop
-	pm.Writeln("for i in 1 2 3; do echo \"Count: $i\"; done")
-	time.Sleep(500 * time.Millisecond)
+	pm.WriteAndExpect("for i in 1 2 3; do echo \"Count: $i\"; done", "$ ", 5*time.Second)
 
 	pm.Writeln("exit")
 	pm.Wait()
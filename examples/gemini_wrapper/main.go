@@ -10,20 +10,16 @@ func main() {
 	// Create a new process manager for 'gemini'
 	// Just running help to see if it captures output
 	pm := pipe.New("gemini", "--help")
-    pm.SetOutputHandler(func(data []byte) {
-        fmt.Print(string(data))
-    })
+	pm.SetOutputHandler(func(data []byte) {
+		fmt.Print(string(data))
+	})
 
-	// Start using pipes (non-interactive mode might be safer for help)
-	if err := pm.StartWithPipes(); err != nil {
-		fmt.Printf("Error starting with pipes: %v\n", err)
-        // try PTY fallback
-        if err := pm.StartWithPTY(); err != nil {
-            panic(err)
-        }
-	} else {
-        defer pm.Stop()
-    }
+	// Start using pipes (non-interactive mode might be safer for help),
+	// falling back to a PTY if pipes cannot be used
+	if err := pm.Start(); err != nil {
+		panic(err)
+	}
+	defer pm.Stop()
 
 	pm.Wait()
 }
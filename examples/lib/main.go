@@ -9,6 +9,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"time"
 
@@ -29,9 +30,12 @@ func Example1_Basic() {
 	pm.StartWithPTY()
 	defer pm.Stop()
 
+	// Wait for the prompt instead of sleeping, so each command is only
+	// sent once the previous one has finished.
+	pm.Expect("$ ", 5*time.Second)
+
 	// Send command
-	pm.Writeln("echo 'Hello World'")
-	time.Sleep(300 * time.Millisecond)
+	pm.WriteAndExpect("echo 'Hello World'", "$ ", 5*time.Second)
 
 	pm.Writeln("exit")
 	pm.Wait()
@@ -39,23 +43,28 @@ func Example1_Basic() {
 
 // Example 2: Collect output to variable
 func Example2_CollectOutput() {
-	pm := pipe.New("bash", "--norc")
-
-	var allOutput string
-	pm.SetOutputHandler(func(data []byte) {
-		allOutput += string(data)
+	// SuppressEcho keeps the typed commands out of the collected output
+	pm := pipe.NewWithConfig(pipe.Config{
+		Command:      "bash",
+		Args:         []string{"--norc"},
+		SuppressEcho: true,
 	})
 
+	var allOutput bytes.Buffer
+	pm.CaptureTo(&allOutput)
+
 	pm.StartWithPTY()
 	defer pm.Stop()
 
-	pm.Writeln("echo 'This will be collected'")
-	time.Sleep(300 * time.Millisecond)
+	pm.Expect("$ ", 5*time.Second)
+
+	pm.WriteAndExpect("echo 'This will be collected'", "$ ", 5*time.Second)
 
 	pm.Writeln("exit")
 	pm.Wait()
+	pm.WaitForOutputDrain()
 
-	fmt.Println("Collected output:", allOutput)
+	fmt.Println("Collected output:", allOutput.String())
 }
 
 // Example 3: Create with configuration
@@ -72,8 +81,9 @@ func Example3_WithConfig() {
 	pm.StartWithPTY()
 	defer pm.Stop()
 
-	pm.Writeln("echo $MY_VAR")
-	time.Sleep(300 * time.Millisecond)
+	pm.Expect("$ ", 5*time.Second)
+
+	pm.WriteAndExpect("echo $MY_VAR", "$ ", 5*time.Second)
 
 	pm.Writeln("exit")
 	pm.Wait()
@@ -90,11 +100,10 @@ func Example4_Python() {
 	pm.StartWithPTY()
 	defer pm.Stop()
 
-	pm.Writeln("print('Hello from Python')")
-	time.Sleep(200 * time.Millisecond)
+	pm.Expect(">>> ", 5*time.Second)
 
-	pm.Writeln("2 + 2")
-	time.Sleep(200 * time.Millisecond)
+	pm.WriteAndExpect("print('Hello from Python')", ">>> ", 5*time.Second)
+	pm.WriteAndExpect("2 + 2", ">>> ", 5*time.Second)
 
 	pm.Writeln("exit()")
 	pm.Wait()
@@ -114,6 +123,10 @@ func Example5_ProcessStatus() {
 	pm.Wait()
 
 	fmt.Printf("Process running: %v\n", pm.IsRunning())
+
+	// State tells an exit apart from a kill and carries the exit code
+	state := pm.State()
+	fmt.Printf("Process status: %v (exit code %d)\n", state.Status, state.ExitCode)
 }
 
 // Example 6: Stream processing
@@ -127,14 +140,24 @@ func Example6_StreamProcessing() {
 		fmt.Printf("[Real-time]: %s", str)
 	})
 
+	// Further handlers run after it for the same chunk
+	var received int
+	pm.AddOutputHandler(func(data []byte) {
+		received += len(data)
+	})
+
 	pm.StartWithPTY()
 	defer pm.Stop()
 
-	pm.Writeln("for i in {1..3}; do echo \"Count: $i\"; sleep 0.1; done")
-	time.Sleep(500 * time.Millisecond)
+	pm.Expect("$ ", 5*time.Second)
+
+	pm.WriteAndExpect("for i in {1..3}; do echo \"Count: $i\"; sleep 0.1; done", "$ ", 5*time.Second)
 
 	pm.Writeln("exit")
 	pm.Wait()
+	pm.WaitForOutputDrain()
+
+	fmt.Printf("Received %d bytes\n", received)
 }
 
 // Example 7: Error handling
@@ -152,11 +175,10 @@ func Example7_ErrorHandling() {
 	pm.StartWithPTY()
 	defer pm.Stop()
 
-	pm.Writeln("echo 'Normal output'")
-	time.Sleep(200 * time.Millisecond)
+	pm.Expect("$ ", 5*time.Second)
 
-	pm.Writeln("echo 'Error output' >&2")
-	time.Sleep(200 * time.Millisecond)
+	pm.WriteAndExpect("echo 'Normal output'", "$ ", 5*time.Second)
+	pm.WriteAndExpect("echo 'Error output' >&2", "$ ", 5*time.Second)
 
 	pm.Writeln("exit")
 	pm.Wait()
@@ -173,9 +195,11 @@ func Example8_Writef() {
 	pm.StartWithPTY()
 	defer pm.Stop()
 
+	pm.Expect("$ ", 5*time.Second)
+
 	// Use formatted write
 	pm.Writef("echo 'Number: %d'\n", 42)
-	time.Sleep(200 * time.Millisecond)
+	pm.Expect("$ ", 5*time.Second)
 
 	pm.Writeln("exit")
 	pm.Wait()
@@ -0,0 +1,414 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+)
+
+// ErrExpectTimeout is returned by Expect when the requested pattern does not
+// appear in the output before the timeout elapses.
+var ErrExpectTimeout = errors.New("expect: timed out waiting for output")
+
+// ErrOutputNotStable is returned by WaitForStableOutput and WaitIdle when the
+// output keeps changing until the timeout elapses.
+var ErrOutputNotStable = errors.New("timed out waiting for output to settle")
+
+// maxExpectBuffer bounds the unconsumed output retained for Expect. The
+// buffer may grow to twice this before the oldest bytes are discarded, so
+// that trimming it costs one copy per maxExpectBuffer of output rather than
+// one per chunk.
+const maxExpectBuffer = 1 << 20
+
+// Expect blocks until substr appears in the process output or timeout elapses.
+//
+// Output is scanned as raw bytes rather than line by line, so a prompt that
+// is not followed by a newline (such as a shell's "$ ") matches as soon as it
+// is printed. On success Expect returns everything up to and including the
+// match and consumes it, so the next call only sees later output.
+//
+// Expect observes a copy of the output; handlers installed with
+// SetOutputHandler still receive every chunk. If the output ends before a
+// match is found, the unconsumed output is returned along with io.EOF.
+func (p *ProcessManager) Expect(substr string, timeout time.Duration) ([]byte, error) {
+	pattern := []byte(substr)
+	return p.expect(timeout, func(buf []byte) int {
+		i := bytes.Index(buf, pattern)
+		if i < 0 {
+			return -1
+		}
+		return i + len(pattern)
+	})
+}
+
+// ExpectContext is like Expect but waits until ctx is done rather than for
+// a timeout, returning ctx.Err() if substr has not appeared by then. This
+// lets a long automation flow cancel every pending Expect on shutdown by
+// cancelling one parent context. Nothing is left waiting after it returns,
+// and output is only consumed on a match.
+func (p *ProcessManager) ExpectContext(ctx context.Context, substr string) ([]byte, error) {
+	pattern := []byte(substr)
+	return p.expectUntil(ctx, nil, func(buf []byte) int {
+		i := bytes.Index(buf, pattern)
+		if i < 0 {
+			return -1
+		}
+		return i + len(pattern)
+	})
+}
+
+// WriteAndExpect sends input followed by the line ending, as Writeln does,
+// then waits for expect to appear as Expect does, returning the output up
+// to and including it. It is the usual way to run one command at a prompt:
+//
+//	pm.Expect("$ ", 5*time.Second)
+//	out, err := pm.WriteAndExpect("ls", "$ ", 5*time.Second)
+//
+// Output not yet consumed by an earlier Expect is searched too, so wait for
+// the previous prompt first, as above, or a stale one may match straight
+// away.
+func (p *ProcessManager) WriteAndExpect(input, expect string, timeout time.Duration) ([]byte, error) {
+	if err := p.Writeln(input); err != nil {
+		return nil, err
+	}
+	return p.Expect(expect, timeout)
+}
+
+// ExpectRegexp is like Expect but waits for output matching re, for prompts
+// that vary such as "user@host:~/path$ ". It returns the output up to and
+// including the leftmost match and consumes it.
+//
+// The pattern is applied to all output not yet consumed by an earlier
+// Expect, which may span several reads, so anchors such as ^ refer to the
+// start of that unconsumed output rather than to a line. As with Expect, an
+// OnOutput handler running concurrently still receives every chunk.
+func (p *ProcessManager) ExpectRegexp(re *regexp.Regexp, timeout time.Duration) ([]byte, error) {
+	return p.expect(timeout, func(buf []byte) int {
+		loc := re.FindIndex(buf)
+		if loc == nil {
+			return -1
+		}
+		return loc[1]
+	})
+}
+
+// TryExpect is a non-blocking Expect: it reports whether substr appears in
+// the output that has already arrived and not yet been consumed, and if so
+// returns and consumes the output up to and including it, just as Expect
+// would. Otherwise it returns false and leaves the output untouched, so it
+// suits polling loops and checks such as "has an error banner appeared?".
+func (p *ProcessManager) TryExpect(substr string) (matched bool, data []byte) {
+	p.outMu.Lock()
+	defer p.outMu.Unlock()
+
+	i := bytes.Index(p.outBuf, []byte(substr))
+	if i < 0 {
+		return false, nil
+	}
+	return true, p.consumeLocked(i + len(substr))
+}
+
+// consumeLocked removes and returns the first end bytes of the unconsumed
+// output. The caller must hold p.outMu.
+func (p *ProcessManager) consumeLocked(end int) []byte {
+	out := bytes.Clone(p.outBuf[:end])
+	p.outBuf = append(p.outBuf[:0], p.outBuf[end:]...)
+	return out
+}
+
+// ExpectAny is like Expect but waits for whichever of patterns appears first
+// in the output, for branching on prompts that vary, such as "password:"
+// versus "(yes/no)?". It returns the index of the pattern that matched along
+// with the output up to and including it, which is consumed. If several
+// patterns match, the one that appears earliest in the output wins, and of
+// those starting at the same place the first listed.
+//
+// If none matches in time the index is -1 and the error ErrExpectTimeout,
+// and if the output ends first it is -1 with io.EOF.
+func (p *ProcessManager) ExpectAny(timeout time.Duration, patterns ...string) (index int, matched []byte, err error) {
+	index = -1
+	matched, err = p.expect(timeout, func(buf []byte) int {
+		start, end := -1, -1
+		for i, pattern := range patterns {
+			j := bytes.Index(buf, []byte(pattern))
+			if j >= 0 && (start < 0 || j < start) {
+				start, end, index = j, j+len(pattern), i
+			}
+		}
+		return end
+	})
+	if err != nil {
+		index = -1
+	}
+	return index, matched, err
+}
+
+// expect waits until match reports the end offset of a match in the
+// unconsumed output, then consumes and returns the output up to that offset.
+func (p *ProcessManager) expect(timeout time.Duration, match func([]byte) int) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	return p.expectUntil(context.Background(), timer.C, match)
+}
+
+// expectUntil is expect ending with ctx.Err() when ctx is done, or with
+// ErrExpectTimeout when expired fires. A nil expired never fires.
+func (p *ProcessManager) expectUntil(ctx context.Context, expired <-chan time.Time, match func([]byte) int) ([]byte, error) {
+	for {
+		p.outMu.Lock()
+		if end := match(p.outBuf); end >= 0 {
+			out := p.consumeLocked(end)
+			p.outMu.Unlock()
+			return out, nil
+		}
+		if p.outEOF {
+			out := bytes.Clone(p.outBuf)
+			p.outBuf = p.outBuf[:0]
+			p.outMu.Unlock()
+			return out, io.EOF
+		}
+		wake := p.outWakeLocked()
+		p.outMu.Unlock()
+
+		select {
+		case <-wake:
+		case <-expired:
+			return nil, ErrExpectTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// DrainOutput waits up to timeout for the process's output to end, as
+// WaitForOutputDrain does, then returns and consumes all output not yet
+// consumed by Expect or an earlier DrainOutput. Call it after Wait to
+// collect the last of a program's output, such as the final line a REPL
+// printed just before exiting. If the output has not ended by the timeout,
+// whatever has arrived so far is returned.
+//
+// Like Expect, it only sees the most recent megabyte of unconsumed output.
+func (p *ProcessManager) DrainOutput(timeout time.Duration) []byte {
+	p.mu.Lock()
+	started := p.done != nil
+	p.mu.Unlock()
+
+	if started {
+		p.waitOutputEnd(timeout)
+	}
+
+	p.outMu.Lock()
+	defer p.outMu.Unlock()
+	out := bytes.Clone(p.outBuf)
+	p.outBuf = p.outBuf[:0]
+	return out
+}
+
+// WaitForStableOutput blocks until the total amount of output has not
+// changed for window, which usually means the program has finished its
+// initial render and is waiting for input. It is a prompt-agnostic readiness
+// check for programs whose prompt is unknown, such as full-screen TUIs; when
+// the prompt text is known, Expect is more precise.
+//
+// It returns ErrOutputNotStable if output is still arriving when timeout
+// elapses, and nil straight away once the output has ended.
+func (p *ProcessManager) WaitForStableOutput(window, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		p.outMu.Lock()
+		total, eof := p.outTotal, p.outEOF
+		wake := p.outWakeLocked()
+		p.outMu.Unlock()
+
+		if eof {
+			return nil
+		}
+
+		quiet := time.NewTimer(window)
+		select {
+		case <-wake:
+			quiet.Stop()
+		case <-quiet.C:
+			p.outMu.Lock()
+			unchanged := p.outTotal == total
+			p.outMu.Unlock()
+			if unchanged {
+				return nil
+			}
+		case <-deadline.C:
+			quiet.Stop()
+			return ErrOutputNotStable
+		}
+	}
+}
+
+// WaitIdle blocks until no output has arrived for d, measured from the most
+// recent output (or from the start, if there has been none). Unlike
+// WaitForStableOutput it returns at once if the process has already been
+// quiet for d, which suits waiting for a build or a long-running command to
+// stop printing.
+//
+// It returns ErrOutputNotStable if output is still arriving when timeout
+// elapses, and nil straight away once the output has ended.
+func (p *ProcessManager) WaitIdle(d, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		p.outMu.Lock()
+		last, eof := p.outLast, p.outEOF
+		wake := p.outWakeLocked()
+		p.outMu.Unlock()
+
+		remaining := d - time.Since(last)
+		if eof || remaining <= 0 {
+			return nil
+		}
+
+		quiet := time.NewTimer(remaining)
+		select {
+		case <-wake:
+			quiet.Stop()
+		case <-quiet.C:
+		case <-deadline.C:
+			quiet.Stop()
+			return ErrOutputNotStable
+		}
+	}
+}
+
+// resetOutput prepares the expect buffer for a new session with the given
+// number of output streams. The session itself holds one extra reference,
+// released when the process finally exits, so output is only considered to
+// have ended once the process is gone and every stream is drained.
+func (p *ProcessManager) resetOutput(readers int) {
+	p.outMu.Lock()
+	defer p.outMu.Unlock()
+
+	p.outBuf = p.outBuf[:0]
+	p.outReaders = readers + 1
+	p.outEOF = false
+	p.outLast = time.Now()
+}
+
+// addReaders registers the output streams of a relaunched run with the
+// current session.
+func (p *ProcessManager) addReaders(readers int) {
+	p.outMu.Lock()
+	defer p.outMu.Unlock()
+	p.outReaders += readers
+}
+
+// appendOutput records a chunk of output for Expect and wakes any waiters.
+func (p *ProcessManager) appendOutput(data []byte) {
+	p.outMu.Lock()
+	defer p.outMu.Unlock()
+
+	p.outTotal += int64(len(data))
+	p.outLast = time.Now()
+	p.outBuf = append(p.outBuf, data...)
+	if len(p.outBuf) > 2*maxExpectBuffer {
+		p.outBuf = append(p.outBuf[:0], p.outBuf[len(p.outBuf)-maxExpectBuffer:]...)
+	}
+	p.wakeLocked()
+}
+
+// readerDone records that one output stream has reached EOF, or that the
+// process has exited. Once both have happened for every stream, output held
+// back by FlushMode is delivered, queued handler calls are finished, any
+// recording and log file are closed, and line subscribers are flushed and
+// closed before waiters are woken.
+func (p *ProcessManager) readerDone() {
+	p.outMu.Lock()
+	p.outReaders--
+	last := p.outReaders <= 0
+	if !last {
+		p.wakeLocked()
+	}
+	p.outMu.Unlock()
+
+	if !last {
+		return
+	}
+	p.flushAll()
+	p.stopHandlerQueue()
+	p.endRecording()
+	p.flushEvents()
+	p.flushCollapsers()
+	p.closeLog()
+	p.closeLines()
+
+	p.outMu.Lock()
+	p.outEOF = true
+	p.wakeLocked()
+	p.outMu.Unlock()
+}
+
+// waitOutputEnd waits up to timeout for the output to end, as
+// WaitForOutputDrain does without a limit.
+func (p *ProcessManager) waitOutputEnd(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		p.outMu.Lock()
+		if p.outEOF {
+			p.outMu.Unlock()
+			return
+		}
+		wake := p.outWakeLocked()
+		p.outMu.Unlock()
+
+		select {
+		case <-wake:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// awaitStreams waits up to timeout for every output stream of the current
+// run to reach EOF, leaving only the reference held by the session itself.
+func (p *ProcessManager) awaitStreams(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		p.outMu.Lock()
+		if p.outReaders <= 1 {
+			p.outMu.Unlock()
+			return
+		}
+		wake := p.outWakeLocked()
+		p.outMu.Unlock()
+
+		select {
+		case <-wake:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// outWakeLocked returns a channel that is closed on the next output event.
+// The caller must hold p.outMu.
+func (p *ProcessManager) outWakeLocked() chan struct{} {
+	if p.outWake == nil {
+		p.outWake = make(chan struct{})
+	}
+	return p.outWake
+}
+
+// wakeLocked notifies all goroutines waiting for output.
+// The caller must hold p.outMu.
+func (p *ProcessManager) wakeLocked() {
+	if p.outWake != nil {
+		close(p.outWake)
+		p.outWake = nil
+	}
+}
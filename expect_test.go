@@ -0,0 +1,79 @@
+package pipe
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpectPromptWithoutNewline(t *testing.T) {
+	requireCommand(t, "bash")
+
+	pm := NewWithConfig(Config{
+		Command: "bash",
+		Args:    []string{"--norc", "--noprofile"},
+		Env:     []string{"PS1=$ "},
+	})
+	if err := pm.StartWithPTY(); err != nil {
+		t.Fatalf("StartWithPTY: %v", err)
+	}
+	defer pm.Stop()
+
+	out, err := pm.Expect("$ ", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expect first prompt: %v", err)
+	}
+	if !bytes.HasSuffix(out, []byte("$ ")) {
+		t.Errorf("Expect returned %q, want it to end with the prompt", out)
+	}
+
+	out, err = pm.WriteAndExpect("echo hello", "$ ", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expect prompt after command: %v", err)
+	}
+	if !bytes.Contains(out, []byte("hello\r\n")) {
+		t.Errorf("output %q does not contain the command's output", out)
+	}
+	if !bytes.HasSuffix(out, []byte("$ ")) {
+		t.Errorf("Expect returned %q, want it to end with the prompt", out)
+	}
+}
+
+func TestExpectBufferBounded(t *testing.T) {
+	pm := New("true")
+	chunk := bytes.Repeat([]byte("x"), 32*1024)
+	for i := 0; i < 5*maxExpectBuffer/len(chunk); i++ {
+		pm.appendOutput(chunk)
+		if n := len(pm.outBuf); n > 2*maxExpectBuffer {
+			t.Fatalf("buffer holds %d bytes after %d chunks, want at most %d", n, i+1, 2*maxExpectBuffer)
+		}
+	}
+	if n := len(pm.outBuf); n < maxExpectBuffer {
+		t.Errorf("buffer holds %d bytes, want at least %d", n, maxExpectBuffer)
+	}
+}
+
+// BenchmarkPipesThroughput streams output well past maxExpectBuffer, so
+// that the cost of keeping the Expect buffer bounded shows up.
+func BenchmarkPipesThroughput(b *testing.B) {
+	if testing.Short() {
+		b.Skip("streams 64 MiB per iteration")
+	}
+	requireCommand(b, "head")
+	const size = 64 << 20
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		pm := NewWithConfig(Config{
+			Command: "head",
+			Args:    []string{"-c", strconv.Itoa(size), "/dev/zero"},
+		})
+		if err := pm.StartWithPipes(); err != nil {
+			b.Fatalf("StartWithPipes: %v", err)
+		}
+		if err := pm.Wait(); err != nil {
+			b.Fatalf("Wait: %v", err)
+		}
+		pm.WaitForOutputDrain()
+	}
+}
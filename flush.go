@@ -0,0 +1,122 @@
+package pipe
+
+import (
+	"bytes"
+	"time"
+)
+
+// FlushMode controls how output read from the process is grouped into
+// handler calls.
+type FlushMode int
+
+const (
+	// FlushImmediate passes each chunk to the handlers as soon as it is read.
+	FlushImmediate FlushMode = iota
+	// FlushLine holds output back until a newline, so that handlers are
+	// called with whole lines. An incomplete line is passed on after
+	// Config.FlushInterval, if set, or when the output ends. Note that a
+	// prompt with no newline after it is not seen until then.
+	FlushLine
+	// FlushInterval collects output for Config.FlushInterval and passes it
+	// on in one call. With no interval set it behaves like FlushImmediate.
+	FlushInterval
+)
+
+// String returns the name of the flush mode.
+func (m FlushMode) String() string {
+	switch m {
+	case FlushImmediate:
+		return "immediate"
+	case FlushLine:
+		return "line"
+	case FlushInterval:
+		return "interval"
+	default:
+		return "unknown"
+	}
+}
+
+// flushMaxPending is the most output held back for one stream. Beyond it,
+// everything held is passed on, so a process that never prints a newline
+// cannot make the buffer grow without limit.
+const flushMaxPending = 64 * 1024
+
+// batch holds back a chunk of output from s according to FlushMode and
+// passes on whatever is ready.
+func (p *ProcessManager) batch(s Stream, data []byte) {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	if p.flushMode == FlushInterval && p.flushInterval <= 0 {
+		p.dispatch(s, data)
+		return
+	}
+
+	buf := append(p.flushBuf[s], data...)
+	if len(buf) >= flushMaxPending {
+		p.flushLocked(s, buf)
+		return
+	}
+
+	if p.flushMode == FlushLine {
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			p.dispatch(s, buf[:i+1])
+			buf = append([]byte(nil), buf[i+1:]...)
+		}
+		if len(buf) == 0 {
+			p.flushBuf[s] = nil
+			p.stopFlushTimerLocked(s)
+			return
+		}
+		if p.flushInterval <= 0 {
+			p.flushBuf[s] = buf
+			return
+		}
+	}
+
+	p.flushBuf[s] = buf
+	if p.flushTimer[s] == nil {
+		var t *time.Timer
+		t = time.AfterFunc(p.flushInterval, func() {
+			p.flushMu.Lock()
+			defer p.flushMu.Unlock()
+			// a timer replaced or stopped after it fired has nothing to do
+			if p.flushTimer[s] == t {
+				p.flushLocked(s, p.flushBuf[s])
+			}
+		})
+		p.flushTimer[s] = t
+	}
+}
+
+// flushLocked passes buf, the output held back for s, on to the handlers.
+// The caller must hold p.flushMu.
+func (p *ProcessManager) flushLocked(s Stream, buf []byte) {
+	p.flushBuf[s] = nil
+	p.stopFlushTimerLocked(s)
+	if len(buf) > 0 {
+		p.dispatch(s, buf)
+	}
+}
+
+// stopFlushTimerLocked cancels the timed flush for s, if there is one. The
+// caller must hold p.flushMu.
+func (p *ProcessManager) stopFlushTimerLocked(s Stream) {
+	if t := p.flushTimer[s]; t != nil {
+		t.Stop()
+		p.flushTimer[s] = nil
+	}
+}
+
+// flushAll passes on all output still held back, once the output has ended.
+func (p *ProcessManager) flushAll() {
+	if p.flushMode == FlushImmediate {
+		return
+	}
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	for _, s := range []Stream{StdOut, StdErr} {
+		p.flushLocked(s, p.flushBuf[s])
+	}
+}
@@ -2,4 +2,8 @@ module github.com/liliang-cn/pipeit
 
 go 1.21
 
-require github.com/creack/pty v1.1.21
+require (
+	github.com/creack/pty v1.1.21
+	golang.org/x/sys v0.18.0
+	golang.org/x/term v0.18.0
+)
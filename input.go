@@ -0,0 +1,274 @@
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maxCoalescedInput is the amount of pending input that triggers an
+// immediate flush when Config.InputCoalesceWindow is set.
+const maxCoalescedInput = 64 << 10
+
+// coalesceLocked queues data to be written as part of a batch once the
+// coalesce window has elapsed. An error from an earlier batch is reported
+// by the next call. The caller must hold p.mu.
+func (p *ProcessManager) coalesceLocked(data []byte) (int, error) {
+	if err := p.inputErr; err != nil {
+		p.inputErr = nil
+		return 0, err
+	}
+	if p.pty == nil && p.stdinPipe == nil {
+		return 0, ErrNoInputPipe
+	}
+
+	p.inputBuf = append(p.inputBuf, data...)
+	if len(p.inputBuf) >= maxCoalescedInput {
+		p.flushInputLocked()
+	} else if p.inputTimer == nil {
+		p.inputTimer = time.AfterFunc(p.inputCoalesceWindow, p.flushInput)
+	}
+	return len(data), nil
+}
+
+// flushInput writes any coalesced input to the process once the coalesce
+// window has elapsed.
+func (p *ProcessManager) flushInput() {
+	if p.onInput != nil {
+		p.inputMu.Lock()
+		defer p.inputMu.Unlock()
+	}
+
+	p.mu.Lock()
+	p.flushInputLocked()
+	p.mu.Unlock()
+	p.reportInput()
+}
+
+// flushInputLocked writes any coalesced input to the process, recording a
+// failure for the next Write. What was written is kept for reportInput, as
+// OnInput cannot be called with p.mu held. The caller must hold p.mu, and
+// p.inputMu if OnInput is set.
+func (p *ProcessManager) flushInputLocked() {
+	if p.inputTimer != nil {
+		p.inputTimer.Stop()
+		p.inputTimer = nil
+	}
+	if len(p.inputBuf) == 0 {
+		return
+	}
+
+	n, err := p.writeLocked(p.inputBuf)
+	if err != nil {
+		p.inputErr = err
+	}
+	if n > 0 && p.onInput != nil {
+		p.inputWritten = append(p.inputWritten, p.inputBuf[:n]...)
+	}
+	p.inputBuf = p.inputBuf[:0]
+}
+
+// discardInputLocked drops any coalesced input not yet written, and any
+// error from writing an earlier batch, so that neither reaches a process
+// started later. The caller must hold p.mu.
+func (p *ProcessManager) discardInputLocked() {
+	if p.inputTimer != nil {
+		p.inputTimer.Stop()
+		p.inputTimer = nil
+	}
+	p.inputBuf = nil
+	p.inputErr = nil
+}
+
+// reportInput passes the coalesced input written since the last call to
+// OnInput. The caller must hold p.inputMu, but not p.mu.
+func (p *ProcessManager) reportInput() {
+	if p.onInput == nil {
+		return
+	}
+	p.mu.Lock()
+	written := p.inputWritten
+	p.inputWritten = nil
+	p.mu.Unlock()
+
+	if len(written) > 0 {
+		p.onInput(written)
+	}
+}
+
+// WriteSync writes data to the process without the batching of
+// Config.InputCoalesceWindow: any input still waiting in a batch is written
+// first, then data, and an error from either is returned. When it returns
+// without error, all of data is in the terminal's or pipe's kernel buffer,
+// where the process reads it from; neither mode buffers input in user
+// space, so nothing is left to flush. Use it before Expect when a lost or
+// delayed command would make the match time out.
+//
+// The process may still discard the input before reading it, as programs
+// that switch the terminal mode with TCSAFLUSH do, so for commands sent at
+// start-up it is still best to Expect the prompt first.
+func (p *ProcessManager) WriteSync(data []byte) error {
+	if p.onInput != nil {
+		p.inputMu.Lock()
+		defer p.inputMu.Unlock()
+	}
+
+	p.mu.Lock()
+	p.flushInputLocked()
+	err := p.inputErr
+	p.inputErr = nil
+	n := 0
+	if err == nil {
+		n, err = p.writeLocked(data)
+	}
+	p.mu.Unlock()
+
+	p.reportInput()
+	if n > 0 && p.onInput != nil {
+		p.onInput(data[:n])
+	}
+	return err
+}
+
+// ForwardStdin copies r into the process's standard input in a background
+// goroutine, as a passthrough CLI does with os.Stdin. Forwarding ends when
+// the returned stop function is called, when the process exits or is
+// stopped, or when r returns an error such as io.EOF. Write failures, such
+// as EPIPE after the child closes its input, end forwarding quietly.
+//
+// A blocked Read cannot be interrupted in general. If r supports read
+// deadlines (like pipes and network connections), stop sets an expired
+// deadline to unblock it; otherwise the goroutine exits as soon as its
+// pending Read returns, and whatever that Read produced is discarded.
+func (p *ProcessManager) ForwardStdin(r io.Reader) (stop func()) {
+	quit := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(quit)
+			if d, ok := r.(interface{ SetReadDeadline(time.Time) error }); ok {
+				d.SetReadDeadline(time.Now())
+			}
+		})
+	}
+
+	p.mu.Lock()
+	done, ctx := p.done, p.ctx
+	p.mu.Unlock()
+
+	go func() {
+		select {
+		case <-quit:
+		case <-done:
+			stop()
+		case <-ctx.Done():
+			stop()
+		}
+	}()
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				if werr := p.writeAll(buf[:n]); werr != nil {
+					stop()
+					return
+				}
+			}
+			if err != nil {
+				stop()
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// writeAll writes data to the process, retrying after partial writes.
+func (p *ProcessManager) writeAll(data []byte) error {
+	for len(data) > 0 {
+		n, err := p.Write(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// TypeHuman sends text one character at a time, pausing for a random
+// duration between minDelay and maxDelay after each one, to mimic a person
+// typing. This helps with TUIs that debounce or validate input timing, and
+// with demos and recordings. It is a best-effort convenience: the delays are
+// approximate and the receiving program may still see characters batched.
+//
+// Typing stops early, returning the context error, if the process is
+// stopped while text is being sent.
+func (p *ProcessManager) TypeHuman(text string, minDelay, maxDelay time.Duration) error {
+	if minDelay < 0 || maxDelay < minDelay {
+		return fmt.Errorf("invalid typing delay range [%v, %v]", minDelay, maxDelay)
+	}
+
+	p.mu.Lock()
+	ctx := p.ctx
+	p.mu.Unlock()
+
+	for _, r := range text {
+		if err := p.WriteString(string(r)); err != nil {
+			return err
+		}
+
+		delay := minDelay
+		if maxDelay > minDelay {
+			delay += time.Duration(rand.Int63n(int64(maxDelay - minDelay + 1)))
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// WriteKeys sends each of keys in order, typically the Key constants, for
+// navigating menus in TUIs:
+//
+//	pm.WriteKeys(pipe.KeyArrowDown, pipe.KeyArrowDown, pipe.KeyEnter)
+//
+// Keys are written as raw bytes; no line ending is added. Programs that read
+// a burst of input as one key may need Config.KeyDelay, which pauses between
+// keys. As with TypeHuman, the pause ends early with the context error if
+// the process is stopped.
+func (p *ProcessManager) WriteKeys(keys ...string) error {
+	p.mu.Lock()
+	ctx := p.ctx
+	p.mu.Unlock()
+
+	for i, key := range keys {
+		if i > 0 && p.keyDelay > 0 {
+			timer := time.NewTimer(p.keyDelay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		if err := p.WriteString(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package pipe
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForwardStdin(t *testing.T) {
+	requireCommand(t, "cat")
+
+	pm := New("cat")
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	defer pm.Stop()
+
+	stop := pm.ForwardStdin(strings.NewReader("hello\nworld\n"))
+	defer stop()
+
+	out, err := pm.Expect("world\n", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expect: %v", err)
+	}
+	if string(out) != "hello\nworld\n" {
+		t.Errorf("cat echoed %q, want %q", out, "hello\nworld\n")
+	}
+}
+
+// endReader records when a Read fails, which ends forwarding.
+type endReader struct {
+	*os.File
+	once  sync.Once
+	ended chan struct{}
+}
+
+func (r *endReader) Read(b []byte) (int, error) {
+	n, err := r.File.Read(b)
+	if err != nil {
+		r.once.Do(func() { close(r.ended) })
+	}
+	return n, err
+}
+
+func TestForwardStdinEndsOnExit(t *testing.T) {
+	requireCommand(t, "sh")
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	r := &endReader{File: pr, ended: make(chan struct{})}
+
+	pm := NewWithConfig(Config{
+		Command: "sh",
+		Args:    []string{"-c", `read -r line; echo "got $line"`},
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	defer pm.Stop()
+
+	stop := pm.ForwardStdin(r)
+	pw.WriteString("x\n")
+	if _, err := pm.Expect("got x\n", 5*time.Second); err != nil {
+		t.Fatalf("Expect: %v", err)
+	}
+	if err := pm.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	// The blocked Read is interrupted once the process has exited.
+	select {
+	case <-r.ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarding still reading after the process exited")
+	}
+	stop() // harmless once forwarding has ended
+}
+
+// endlessReader returns a line on every Read and counts the calls.
+type endlessReader struct {
+	reads atomic.Int64
+}
+
+func (r *endlessReader) Read(b []byte) (int, error) {
+	r.reads.Add(1)
+	return copy(b, "line\n"), nil
+}
+
+func TestForwardStdinEndsOnWriteError(t *testing.T) {
+	requireCommand(t, "sh")
+
+	// The child closes its input straight away, so writes fail with EPIPE.
+	pm := NewWithConfig(Config{
+		Command: "sh",
+		Args:    []string{"-c", "exec 0<&-; sleep 10"},
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	defer pm.Stop()
+
+	r := &endlessReader{}
+	stop := pm.ForwardStdin(r)
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		before := r.reads.Load()
+		time.Sleep(100 * time.Millisecond)
+		if r.reads.Load() == before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("forwarding kept going after writes failed")
+		}
+	}
+	if !pm.IsRunning() {
+		t.Error("process exited; want forwarding to end on the write error alone")
+	}
+}
+
+func TestOnInputCoalesced(t *testing.T) {
+	requireCommand(t, "cat")
+
+	var mu sync.Mutex
+	var inputs []string
+	pm := NewWithConfig(Config{
+		Command:             "cat",
+		InputCoalesceWindow: 100 * time.Millisecond,
+		OnInput: func(data []byte) {
+			mu.Lock()
+			inputs = append(inputs, string(data))
+			mu.Unlock()
+		},
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	defer pm.Stop()
+
+	pm.WriteString("a")
+	pm.WriteString("b\n")
+	mu.Lock()
+	if len(inputs) != 0 {
+		t.Errorf("OnInput called with %q before the batch was written", inputs)
+	}
+	mu.Unlock()
+
+	if _, err := pm.Expect("ab\n", 5*time.Second); err != nil {
+		t.Fatalf("Expect: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(inputs) != 1 || inputs[0] != "ab\n" {
+		t.Errorf("OnInput got %q, want the batch [\"ab\\n\"]", inputs)
+	}
+}
+
+func TestRestartDiscardsCoalescedInput(t *testing.T) {
+	requireCommand(t, "cat")
+
+	var mu sync.Mutex
+	var out, in strings.Builder
+	pm := NewWithConfig(Config{
+		Command:             "cat",
+		InputCoalesceWindow: 200 * time.Millisecond,
+		OnOutput: func(data []byte) {
+			mu.Lock()
+			out.Write(data)
+			mu.Unlock()
+		},
+		OnInput: func(data []byte) {
+			mu.Lock()
+			in.Write(data)
+			mu.Unlock()
+		},
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	defer pm.Stop()
+
+	pm.WriteString("stale\n")
+	if err := pm.Restart(); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	pm.WriteString("fresh\n")
+	if _, err := pm.Expect("fresh\n", 5*time.Second); err != nil {
+		t.Fatalf("Expect: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := out.String(); got != "fresh\n" {
+		t.Errorf("restarted process got %q, want only the input written after Restart", got)
+	}
+	if got := in.String(); got != "fresh\n" {
+		t.Errorf("OnInput got %q, want only the input written", got)
+	}
+}
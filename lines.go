@@ -0,0 +1,192 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// lineChannelSize is the number of complete lines a LineChannel buffers
+// before the read loop blocks waiting for the consumer.
+const lineChannelSize = 256
+
+// lineSub splits output into lines for one LineChannel subscriber.
+type lineSub struct {
+	mu       sync.Mutex
+	partial  [2][]byte // incomplete line per stream
+	ch       chan string
+	closed   bool
+	quit     chan struct{} // closed to drop lines rather than block on ch
+	quitOnce sync.Once
+	done     chan struct{} // closed along with ch
+}
+
+// LineChannel returns a channel that receives each complete line of output,
+// with the trailing "\n" or "\r\n" removed. The channel is closed once the
+// process's output has ended and any final unterminated line has been sent,
+// so it can be consumed with:
+//
+//	for line := range pm.LineChannel() {
+//		...
+//	}
+//
+// Lines from stdout and stderr are each kept intact but interleaved in
+// arrival order. The channel buffers a bounded number of lines; when it is
+// full the read loop blocks, applying backpressure to the process, so a
+// caller that stops reading before the channel is closed must use
+// LineChannelContext instead and cancel it. Once Stop is called, lines that
+// do not fit are dropped rather than waited for. Each call returns a new,
+// independent channel, and handlers and Expect continue to see the raw
+// output.
+func (p *ProcessManager) LineChannel() <-chan string {
+	return p.LineChannelContext(context.Background())
+}
+
+// LineChannelContext is like LineChannel, but cancelling ctx unsubscribes:
+// lines not yet received are dropped and the channel is closed.
+func (p *ProcessManager) LineChannelContext(ctx context.Context) <-chan string {
+	sub := &lineSub{
+		ch:   make(chan string, lineChannelSize),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	if p.linesClosed {
+		p.mu.Unlock()
+		sub.close()
+		return sub.ch
+	}
+	p.lineSubs = append(p.lineSubs, sub)
+	p.mu.Unlock()
+
+	if ctx.Done() != nil {
+		go p.watchLineSub(ctx, sub)
+	}
+	return sub.ch
+}
+
+// watchLineSub unsubscribes sub once ctx is cancelled.
+func (p *ProcessManager) watchLineSub(ctx context.Context, sub *lineSub) {
+	select {
+	case <-ctx.Done():
+	case <-sub.done:
+		return
+	}
+	sub.release()
+
+	p.mu.Lock()
+	for i, s := range p.lineSubs {
+		if s == sub {
+			p.lineSubs = append(p.lineSubs[:i:i], p.lineSubs[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+	sub.close()
+}
+
+// Lines is shorthand for LineChannel, for range loops over the output:
+//
+//	for line := range pm.Lines() {
+//		...
+//	}
+func (p *ProcessManager) Lines() <-chan string {
+	return p.LineChannel()
+}
+
+// feedLines passes a chunk of output to every LineChannel subscriber.
+func (p *ProcessManager) feedLines(s Stream, data []byte) {
+	p.mu.Lock()
+	subs := p.lineSubs
+	p.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.feed(s, data)
+	}
+}
+
+// releaseLinesLocked makes every LineChannel drop lines it has no room for,
+// so that a subscriber that stopped reading cannot hold up the read loop
+// once the process is stopped. The caller must hold p.mu.
+func (p *ProcessManager) releaseLinesLocked() {
+	for _, sub := range p.lineSubs {
+		sub.release()
+	}
+}
+
+// closeLines flushes and closes every LineChannel once output has ended.
+func (p *ProcessManager) closeLines() {
+	p.mu.Lock()
+	subs := p.lineSubs
+	p.lineSubs = nil
+	p.linesClosed = true
+	p.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+func (l *lineSub) feed(s Stream, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+	buf := append(l.partial[s], data...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		if !l.send(string(bytes.TrimSuffix(buf[:i], []byte("\r")))) {
+			l.partial[s] = nil
+			return
+		}
+		buf = buf[i+1:]
+	}
+	l.partial[s] = append(l.partial[s][:0], buf...)
+}
+
+// send passes line to the subscriber, blocking while the channel is full
+// unless the subscriber has been released. It reports whether the line was
+// sent. The caller must hold l.mu.
+func (l *lineSub) send(line string) bool {
+	select {
+	case <-l.quit:
+		return false
+	default:
+	}
+	select {
+	case l.ch <- line:
+		return true
+	case <-l.quit:
+		return false
+	}
+}
+
+// release makes the subscriber drop lines instead of blocking, including a
+// send already in progress.
+func (l *lineSub) release() {
+	l.quitOnce.Do(func() { close(l.quit) })
+}
+
+func (l *lineSub) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+	for s, rest := range l.partial {
+		if len(rest) > 0 {
+			l.send(string(bytes.TrimSuffix(rest, []byte("\r"))))
+			l.partial[s] = nil
+		}
+	}
+	l.closed = true
+	close(l.ch)
+	close(l.done)
+}
@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// drained reports whether WaitForOutputDrain returns within timeout.
+func drained(pm *ProcessManager, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		pm.WaitForOutputDrain()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestLineChannelAbandonedThenStop(t *testing.T) {
+	requireCommand(t, "yes")
+
+	pm := New("yes")
+	lines := pm.LineChannel()
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	// Fill the channel so the read loop blocks on it.
+	for len(lines) < cap(lines) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pm.Stop()
+	if !drained(pm, 5*time.Second) {
+		t.Fatal("WaitForOutputDrain blocked on an unread LineChannel after Stop")
+	}
+	for range lines {
+	}
+}
+
+func TestLineChannelContextCancel(t *testing.T) {
+	requireCommand(t, "seq")
+
+	pm := New("seq", "100000")
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := pm.LineChannelContext(ctx)
+	all := pm.LineChannel()
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	if got := <-lines; got != "1" {
+		t.Errorf("first line = %q, want %q", got, "1")
+	}
+	cancel()
+
+	// The cancelled channel is closed without being read to the end.
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-lines:
+		case <-timeout:
+			t.Fatal("channel not closed after its context was cancelled")
+		}
+	}
+
+	var n int
+	for range all {
+		n++
+	}
+	if n != 100000 {
+		t.Errorf("other subscriber got %d lines, want 100000", n)
+	}
+	if err := pm.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+}
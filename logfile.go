@@ -0,0 +1,128 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DefaultLogMaxBackups is the number of rotated log files kept when
+// Config.LogMaxBytes is set and Config.LogMaxBackups is not.
+const DefaultLogMaxBackups = 5
+
+// rotatingLog appends output to a file, renaming it to path.1, path.2 and
+// so on once it grows past a size limit. The file is opened on first use,
+// so that it can be closed when the process stops and reopened if it is
+// restarted.
+type rotatingLog struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64 // rotate when exceeded; zero means never
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+func newRotatingLog(path string, maxBytes int64, maxBackups int) *rotatingLog {
+	if maxBackups <= 0 {
+		maxBackups = DefaultLogMaxBackups
+	}
+	return &rotatingLog{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+}
+
+// Write appends data to the log, rotating it first if data would take it
+// past the size limit. A single chunk is never split across files.
+func (l *rotatingLog) Write(data []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		if err := l.open(); err != nil {
+			return 0, err
+		}
+	}
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(data)
+	l.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file. A later Write opens it again.
+func (l *rotatingLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+func (l *rotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open log file: %w", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, moves the current
+// file to path.1 and starts a new one.
+func (l *rotatingLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("rotate log file: %w", err)
+	}
+	l.f = nil
+
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate log file: %w", err)
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return fmt.Errorf("rotate log file: %w", err)
+	}
+	return l.open()
+}
+
+// writeLog appends a chunk of output to Config.LogFile, if set.
+func (p *ProcessManager) writeLog(data []byte) {
+	if p.logFile == nil {
+		return
+	}
+	if p.logCollapser != nil {
+		p.logCollapser.Write(data)
+		return
+	}
+	p.appendLog(data)
+}
+
+// appendLog writes data to Config.LogFile, reporting any error.
+func (p *ProcessManager) appendLog(data []byte) {
+	if _, err := p.logFile.Write(data); err != nil && p.onWriterError != nil {
+		p.onWriterError(err)
+	}
+}
+
+// closeLog closes Config.LogFile, if set, reporting any error.
+func (p *ProcessManager) closeLog() {
+	if p.logFile == nil {
+		return
+	}
+	if err := p.logFile.Close(); err != nil && p.onWriterError != nil {
+		p.onWriterError(err)
+	}
+}
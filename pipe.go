@@ -6,127 +6,871 @@
 package pipe
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/creack/pty"
 )
 
 // Special terminal key sequences
 const (
-	KeyEnter     = "\r"
-	KeyArrowUp   = "\x1b[A"
-	KeyArrowDown = "\x1b[B"
-	KeyArrowLeft = "\x1b[D"
+	KeyEnter      = "\r"
+	KeyArrowUp    = "\x1b[A"
+	KeyArrowDown  = "\x1b[B"
+	KeyArrowLeft  = "\x1b[D"
 	KeyArrowRight = "\x1b[C"
-	KeyTab       = "\t"
-	KeyEscape    = "\x1b"
-	KeyCtrlC     = "\x03"
+	KeyTab        = "\t"
+	KeyEscape     = "\x1b"
+	KeyBackspace  = "\x7f"
+)
+
+// Editing and navigation keys, as sent by xterm
+const (
+	KeyInsert   = "\x1b[2~"
+	KeyDelete   = "\x1b[3~"
+	KeyHome     = "\x1b[H"
+	KeyEnd      = "\x1b[F"
+	KeyPageUp   = "\x1b[5~"
+	KeyPageDown = "\x1b[6~"
+)
+
+// Function keys, as sent by xterm
+const (
+	KeyF1  = "\x1bOP"
+	KeyF2  = "\x1bOQ"
+	KeyF3  = "\x1bOR"
+	KeyF4  = "\x1bOS"
+	KeyF5  = "\x1b[15~"
+	KeyF6  = "\x1b[17~"
+	KeyF7  = "\x1b[18~"
+	KeyF8  = "\x1b[19~"
+	KeyF9  = "\x1b[20~"
+	KeyF10 = "\x1b[21~"
+	KeyF11 = "\x1b[23~"
+	KeyF12 = "\x1b[24~"
+)
+
+// Control characters
+const (
+	KeyCtrlA = "\x01" // start of line in readline
+	KeyCtrlC = "\x03" // interrupt
+	KeyCtrlD = "\x04" // end of input
+	KeyCtrlE = "\x05" // end of line in readline
+	KeyCtrlL = "\x0c" // redraw the screen
+	KeyCtrlR = "\x12" // reverse history search in readline
+	KeyCtrlU = "\x15" // erase the line in readline
+	KeyCtrlW = "\x17" // erase the previous word
+	KeyCtrlZ = "\x1a" // suspend
 )
 
 // OutputHandler is a callback function type used to process output data
 // received from the managed process's stdout or stderr.
 type OutputHandler func([]byte)
 
+// PanicHandler is called with the recovered value when an OutputHandler
+// panics, along with the chunk of output it was processing.
+type PanicHandler func(recovered any, data []byte)
+
+// Stream identifies which output stream of the process a chunk of output
+// was read from.
+type Stream int
+
+const (
+	StdOut Stream = iota
+	StdErr
+)
+
+// String returns "stdout" or "stderr".
+func (s Stream) String() string {
+	if s == StdErr {
+		return "stderr"
+	}
+	return "stdout"
+}
+
+// StreamHandler is a callback that receives output from both streams along
+// with the stream it came from.
+type StreamHandler func(s Stream, data []byte)
+
 // ProcessManager handles the lifecycle and IO of a system process.
 // It manages the execution, provides methods for writing to stdin,
 // and uses handlers to capture stdout and stderr.
 type ProcessManager struct {
 	cmd       *exec.Cmd
 	pty       *os.File
+	errPTY    *os.File
+	parentCtx context.Context // caller's context, outlives Restart
 	ctx       context.Context
 	cancel    context.CancelFunc
 	stdinPipe io.WriteCloser
-	onOutput  OutputHandler
-	onError   OutputHandler
+	outPipes  []*os.File         // read ends of the output pipes in pipes mode
+	conpty    *conPTY            // pseudo console backing pty on Windows
+	winsize   *pty.Winsize       // last size requested with SetWindowSize
+	stopWinch func()             // ends InheritWindowSize tracking
+	handlers  [2][]OutputHandler // per stream, in registration order
 	mu        sync.Mutex
 	running   bool
+	stopped   bool
+	mode      startMode
+
+	// done is closed once the process has exited and waitErr and state
+	// are set.
+	done       chan struct{}
+	waitErr    error
+	stderrTail *ringBuffer // last stderr output of the current run
+	state      *os.ProcessState
+	softCtx    context.Context
+
+	startTime     time.Time
+	firstByteTime time.Time
+	exitTime      time.Time
+
+	// argv and environ are the values the child was started with.
+	argv    []string
+	environ []string
+
+	// Unconsumed output retained for Expect, guarded by outMu.
+	outMu      sync.Mutex
+	outBuf     []byte
+	outWake    chan struct{}
+	outReaders int
+	outEOF     bool
+	outTotal   int64     // bytes of output seen, for WaitForStableOutput
+	outLast    time.Time // arrival of the latest output, for WaitIdle
+
+	bytesRead [2]atomic.Int64 // per stream
+
+	readDelay      time.Duration
+	readBufferSize int
+
+	queryMu         sync.Mutex
+	queryTerminator string
+
+	pauseWhenSlow time.Duration
+	slowHandlers  int // handlers currently over the PauseWhenSlow threshold
+	paused        bool
+
+	lineSubs    []*lineSub
+	linesClosed bool
+
+	forwarding bool
+
+	maxTotalRuntime time.Duration
+	totalRuntime    time.Duration // runtime of previous runs
+	runtimeTimer    *time.Timer
+	timeout         time.Duration
+	timeoutTimer    *time.Timer
+	timedOut        bool // the current session was stopped by timeoutTimer
+
+	scrollback *ringBuffer
+
+	onHandlerPanic PanicHandler
+	restartOnCodes []int
+	restartDelay   time.Duration
+	combineOutput  bool
+	preferPTY      bool
+	beforeStart    func(*exec.Cmd) error
+	baseAttr       *syscall.SysProcAttr // SysProcAttr as configured, see saveAttrLocked
+	attrSaved      bool
+	extraFiles     []*os.File
+	killGroup      bool
+	onExit         func(error)
+	onClose        func(CloseReason)
+	stdin          io.Reader
+
+	cast    *castRecorder // recording started with StartRecording
+	castErr error         // error from a recording that has ended
+
+	stripANSI   bool
+	strippers   [2]ansiStripper // per stream, when stripANSI is set
+	onRawOutput StreamHandler
+
+	suppressEcho bool
+	echo         echoFilter // input awaiting its echo, when suppressEcho is set
+
+	onInput func([]byte)
+	inputMu sync.Mutex // orders calls to onInput
+
+	lineEnding string        // appended by Writeln; "" means "\n"
+	keyDelay   time.Duration // pause between keys in WriteKeys
+
+	inputCoalesceWindow time.Duration
+	inputBuf            []byte
+	inputTimer          *time.Timer
+	inputErr            error
+	inputWritten        []byte // coalesced input written but not yet passed to onInput
+	limiter             writeLimiter
+
+	captureMu    sync.Mutex
+	captures     [2][]*bytes.Buffer // indexed by stream
+	writers      [2]io.Writer       // indexed by stream
+	eventWriter  io.Writer
+	eventPending [2][]byte // incomplete UTF-8 held back from eventWriter
+
+	onWriterError func(error)
+	logFile       *rotatingLog // Config.LogFile, if set
+
+	collapseProgress bool
+	collapsers       [2]*ProgressCollapser // per stream, in front of captures and writers
+	collapseErrs     []error               // writer errors met by collapsers, guarded by captureMu
+	logCollapser     *ProgressCollapser    // in front of logFile
+
+	screen *screen
+
+	flushMode     FlushMode
+	flushInterval time.Duration
+	flushMu       sync.Mutex
+	flushBuf      [2][]byte      // output held back per stream
+	flushTimer    [2]*time.Timer // pending timed flush per stream
+
+	asyncBufferSize int              // zero unless AsyncHandlers is set
+	handlerQueue    chan handlerCall // chunks awaiting the handlers
+	handlersDone    chan struct{}    // closed once handlerQueue is drained
 }
 
+// startMode records how the process was started, so that it can be
+// relaunched the same way.
+type startMode int
+
+const (
+	modeNone startMode = iota
+	modePTY
+	modeSplitPTY
+	modePipes
+	modePipesRaw
+)
+
+// DefaultReadBufferSize is the read buffer size used when
+// Config.ReadBufferSize is not set.
+const DefaultReadBufferSize = 4096
+
+// DefaultAsyncBufferSize is the number of chunks queued for the handlers
+// when Config.AsyncHandlers is set and Config.AsyncBufferSize is not.
+const DefaultAsyncBufferSize = 256
+
+// DefaultGracePeriod is how long a graceful shutdown waits for the process
+// to exit after SIGTERM before it is forcibly killed.
+const DefaultGracePeriod = 5 * time.Second
+
+// DefaultRestartDelay is the least time between the starts of two runs
+// relaunched by RestartOnCodes when Config.RestartDelay is not set.
+const DefaultRestartDelay = time.Second
+
 // Config specifies the parameters for creating a new ProcessManager.
 type Config struct {
+	// Context, if set, bounds the lifetime of the process: it is killed as
+	// soon as the context is done. Stop works regardless.
+	Context context.Context
 	// Command is the name or path of the executable.
 	Command string
 	// Args is the list of arguments for the command.
 	Args []string
+	// Shell runs Command as a command line through a shell, so that pipes,
+	// redirections, globs and variables work: $SHELL -c on Unix, and
+	// %ComSpec% /C on Windows. On Unix /bin/sh is used instead if SHELL is
+	// unset or is not a POSIX shell such as bash or zsh, since fish and csh
+	// take neither the same syntax nor positional parameters after -c. The
+	// line is passed as is, so quoting follows that shell's rules and any
+	// untrusted text in it must be quoted by the caller, or passed in Args
+	// instead. On Unix Args become the positional parameters $1, $2 and so
+	// on; on Windows they are appended to the command line, quoted for a
+	// program that parses its arguments in the usual way.
+	Shell bool
 	// Env specifies the environment variables for the process.
 	// If nil, the current process environment is used.
 	Env []string
+	// Dir is the working directory of the process.
+	// If empty, the process runs in the current directory.
+	Dir string
+	// Stdin, if set, is copied to the process's standard input in pipes
+	// mode, like "cmd < file", and standard input is closed when it reaches
+	// EOF. Write then fails, since the process has only one input. It is
+	// not used in PTY modes or by StartWithPipesRaw. It is read only once:
+	// a run started by Restart or RestartOnCodes is fed from where the
+	// previous run left off, which is usually EOF.
+	Stdin io.Reader
 	// OnOutput is the handler for stdout data.
 	OnOutput OutputHandler
 	// OnError is the handler for stderr data.
 	OnError OutputHandler
+	// OnExit, if set, is called from a background goroutine once the
+	// process has exited, with the error Wait returns. Output may still be
+	// in flight; use WaitForOutputDrain if it must have been delivered.
+	OnExit func(error)
+	// OnClose, if set, is called from the read loop of each output stream
+	// once it has ended, with the reason it ended; in PTY mode there is one
+	// such stream, in pipes mode usually two. It tells a process that
+	// closed its output normally apart from one whose output failed.
+	OnClose func(reason CloseReason)
+	// CombineOutput, in pipes mode, sends stderr through the same pipe as
+	// stdout, as a PTY does, so both reach OnOutput in the order they were
+	// written and OnError is not called.
+	CombineOutput bool
+	// KillProcessGroup controls whether Stop, StopGracefully and context
+	// cancellation signal the whole process group rather than only the
+	// process itself, so that children it spawned (such as a sleep run by
+	// a shell) do not outlive it. In pipes mode the process is started in
+	// a group of its own for this; PTY sessions always have one. If nil it
+	// defaults to true. It has no effect on Windows.
+	KillProcessGroup *bool
+	// PreferPTY makes Start try PTY mode first, falling back to pipes,
+	// instead of the other way round.
+	PreferPTY bool
+	// BeforeStart, if set, is called with the command each time the
+	// process is about to be launched, including by Restart and
+	// RestartOnCodes, so that it can adjust what Config does not cover,
+	// such as SysProcAttr. It runs before the standard streams are
+	// connected, and any set here are replaced. If it returns an error the
+	// start fails with that error, and Start does not try the other mode.
+	// It is called with the ProcessManager locked, so it must not call
+	// any of its methods, which would deadlock.
+	// On Windows in PTY mode the process is created directly rather than
+	// by exec.Cmd, and only Path, Args, Env, Dir and SysProcAttr.CmdLine
+	// are used.
+	BeforeStart func(cmd *exec.Cmd) error
+	// ExtraFiles are open files passed on to a process started in pipes
+	// mode, as with exec.Cmd.ExtraFiles: entry i becomes file descriptor
+	// 3+i in the child, so the first is fd 3. They are not passed in PTY
+	// modes, where the child's descriptors are set up for the terminal.
+	// The caller keeps ownership and may close its copies once the process
+	// has started. Windows does not support them, and starting the process
+	// with any set fails there.
+	ExtraFiles []*os.File
+	// ReadDelay inserts an artificial delay after every read, before the
+	// data is delivered, simulating a slow-producing process.
+	// It is intended only for testing how consumers cope with slow output
+	// and should be left at zero in production.
+	ReadDelay time.Duration
+	// ReadBufferSize is the size of the buffer each output stream is read
+	// into, and so the largest chunk passed to a handler in one call.
+	// Larger buffers mean fewer handler calls for high-throughput commands;
+	// smaller ones hand over output in smaller pieces. Zero or negative
+	// values use DefaultReadBufferSize.
+	ReadBufferSize int
+	// QueryTerminator, if set, marks the end of a multi-line Query response.
+	// A response line equal to it ends the response and is not returned.
+	QueryTerminator string
+	// PauseWhenSlow, if positive, suspends the process with SIGSTOP whenever
+	// delivering a chunk of output to a handler takes longer than this, and
+	// resumes it with SIGCONT once the handler returns. This bounds how far
+	// a chatty producer can run ahead of a slow consumer, but pausing may
+	// disturb timing-sensitive programs. It has no effect on Windows.
+	PauseWhenSlow time.Duration
+	// MaxTotalRuntime, if positive, bounds the cumulative runtime of every
+	// run of the process managed by this ProcessManager. When the budget is
+	// used up the running process is stopped gracefully, and Restart fails
+	// with ErrRuntimeExceeded.
+	MaxTotalRuntime time.Duration
+	// Timeout, if positive, stops the process with Stop once it has been
+	// running this long, as a safety net against commands that hang. The
+	// time is counted from the start of the session, across any relaunches
+	// by RestartOnCodes, and begins again with each Restart. Wait then
+	// returns ErrTimeout.
+	Timeout time.Duration
+	// ScrollbackBytes, if positive, retains the last ScrollbackBytes of
+	// output from both streams for non-consuming inspection with Contains
+	// and LastOutput.
+	ScrollbackBytes int
+	// RestartOnCodes lists exit codes after which the process is launched
+	// again automatically, in the same mode, for programs that ask to be
+	// restarted by exiting with a dedicated code. Any other exit, or a stop
+	// requested through the ProcessManager, is final. Relaunching also ends
+	// once MaxTotalRuntime is used up. Wait, Expect and LineChannel treat
+	// the relaunched runs as one continuous session. If a relaunch fails to
+	// start, the session ends and Wait returns the start error joined with
+	// the exit status that prompted it.
+	RestartOnCodes []int
+	// RestartDelay is the least time between the starts of two runs
+	// relaunched by RestartOnCodes, so that a command that keeps exiting
+	// with a listed code straight away is not relaunched in a tight loop.
+	// Zero or negative values use DefaultRestartDelay.
+	RestartDelay time.Duration
+	// OutputWriter, if set, receives a copy of all stdout output, as with
+	// SetOutputWriter.
+	OutputWriter io.Writer
+	// ErrorWriter, if set, receives a copy of all stderr output, as with
+	// SetErrorWriter.
+	ErrorWriter io.Writer
+	// OnWriterError is called with any error returned by OutputWriter or
+	// ErrorWriter, or from writing LogFile. Without it such errors are
+	// ignored.
+	OnWriterError func(error)
+	// LogFile, if set, is the path of a file that all output from both
+	// streams is appended to, independently of the handlers. It is opened
+	// when the first output arrives and closed when the output ends or
+	// Stop is called, and reopened if the process is restarted.
+	LogFile string
+	// LogMaxBytes, if positive, rotates LogFile once writing to it would
+	// take it past this size: the file is renamed to LogFile+".1", older
+	// logs move up to ".2" and so on, and a new file is started.
+	LogMaxBytes int64
+	// LogMaxBackups is the number of rotated logs kept; older ones are
+	// removed. Zero or negative values use DefaultLogMaxBackups.
+	LogMaxBackups int
+	// CollapseProgress runs the copies of the output kept by CaptureTo,
+	// CaptureStderrTo, OutputWriter, ErrorWriter and LogFile through a
+	// ProgressCollapser, so that a progress bar redrawn with "\r" leaves
+	// only its final state in them rather than every frame. Handlers,
+	// Expect and LineChannel still see the raw output. A line still
+	// incomplete when the output ends is written then.
+	CollapseProgress bool
+	// OnHandlerPanic is called when an output handler panics. The panic is
+	// always recovered so that one bad chunk does not stop output delivery;
+	// without this callback it is reported to the stderr handlers as a
+	// "[Handler Panic]" message, as read errors are.
+	OnHandlerPanic PanicHandler
+	// InputCoalesceWindow, if positive, batches writes made within this
+	// window into a single write to the process, reducing syscalls when
+	// input arrives as many tiny writes (such as forwarded keystrokes).
+	// Byte order is preserved and pending input is flushed once the window
+	// elapses, so added latency is at most the window. Write errors are
+	// reported by the following Write call. Input still pending when the
+	// process is stopped is discarded, so it cannot reach a restarted one.
+	InputCoalesceWindow time.Duration
+	// WriteRateLimit, if positive, paces Write and the methods built on
+	// it, such as Writeln and ForwardStdin, to at most this many bytes per
+	// second, for programs that drop characters when a large script is
+	// pasted into them. A Write returns once all of its data has gone out,
+	// or early with an error if the process is stopped. It only
+	// applies while a limit is set; SetWriteRateLimit changes or removes
+	// it. WriteSync is not paced.
+	WriteRateLimit int
+	// AsyncHandlers calls OnOutput, OnError and any added handlers from a
+	// goroutine of their own instead of from the read loops, so a slow
+	// handler does not stop the process's output from being read. Chunks
+	// are queued and delivered one at a time in the order they were read,
+	// across both streams. When the queue is full the read loops block
+	// until a handler catches up; no output is dropped. Expect, LineChannel
+	// and the other readers are not delayed by the queue.
+	AsyncHandlers bool
+	// FlushMode controls how output is grouped into handler calls. The
+	// default, FlushImmediate, passes on each chunk as it is read.
+	FlushMode FlushMode
+	// FlushInterval is the batching window for FlushInterval mode, and in
+	// FlushLine mode the time after which an incomplete line, such as a
+	// prompt, is passed on anyway. Zero in FlushLine mode holds incomplete
+	// lines until they are completed or the output ends.
+	FlushInterval time.Duration
+	// AsyncBufferSize is the number of chunks AsyncHandlers queues before
+	// the read loops block. Zero or negative values use
+	// DefaultAsyncBufferSize.
+	AsyncBufferSize int
+	// StripANSI removes terminal escape sequences, such as colors and
+	// cursor movement, from output before it is passed to the handlers,
+	// as the StripANSI function does. Sequences split across reads are
+	// handled. Expect, CaptureTo, the output writers and the other readers
+	// still see the raw output.
+	StripANSI bool
+	// SuppressEcho removes the terminal's echo of written input from the
+	// start of PTY output, so that after Writeln("echo hi") the output is
+	// "hi\r\n" rather than "echo hi\r\nhi\r\n". Line ends translated to
+	// "\r\n", control characters echoed as "^C" and the escape sequences
+	// and carriage returns a line editor adds while redrawing are allowed
+	// for. The echo is matched byte by byte; at the first output that does
+	// not match, as when the program has turned echo off, the rest of the
+	// input is forgotten and output passes through unchanged. It has no
+	// effect in pipes mode, where nothing is echoed.
+	SuppressEcho bool
+	// OnRawOutput, if set, receives all output from both streams as read,
+	// before StripANSI is applied. It is called before the other handlers.
+	OnRawOutput StreamHandler
+	// OnInput, if set, is called with everything written to the process's
+	// standard input through Write and the methods built on it, such as
+	// Writeln and ForwardStdin, for an audit trail of what was sent. Calls
+	// are made in the order of the writes, after each write, with the bytes
+	// actually written; with InputCoalesceWindow, that is once a batch has
+	// been written. It must not write to the process itself. Input from
+	// Config.Stdin is not reported.
+	OnInput func(data []byte)
+	// LineEnding is appended to each line sent with Writeln. It defaults to
+	// "\n"; set it to KeyEnter for programs that wait for a carriage return.
+	LineEnding string
+	// KeyDelay is the pause WriteKeys makes between keys. Zero sends them
+	// back to back.
+	KeyDelay time.Duration
+	// Credential, if set, runs the process as another user and group, for
+	// a privileged program that drops privileges for the command it runs.
+	// It is only supported on Unix; elsewhere starting the process fails.
+	Credential *Credential
+	// Rows and Cols set the size of the PTY from the moment the process
+	// starts, for programs that only read it at launch. If either is zero,
+	// the PTY takes the size of the terminal on the current program's
+	// standard output, if there is one. SetWindowSize can change it later.
+	Rows, Cols uint16
+	// TrackScreen feeds all output through a terminal screen model so the
+	// rendered screen can be read with Snapshot. The screen starts at the
+	// size given by Rows and Cols, or 24x80, and follows SetWindowSize.
+	TrackScreen bool
+}
+
+// Credential identifies the user and groups a process runs as, mirroring
+// syscall.Credential on Unix.
+type Credential struct {
+	Uid         uint32   // user ID
+	Gid         uint32   // primary group ID
+	Groups      []uint32 // supplementary group IDs
+	NoSetGroups bool     // if set, the supplementary groups are left unchanged
 }
 
+// ErrNoPTY is returned by PTY-only operations such as SetWindowSize when the
+// process is not attached to a PTY, for example in pipes mode. Callers that
+// set a window size unconditionally can ignore it with errors.Is.
+var ErrNoPTY = errors.New("no PTY session active")
+
+// ErrNotStarted is returned by methods that act on a running or finished
+// process, such as Wait, Signal and Restart, when the process has not been
+// started.
+var ErrNotStarted = errors.New("process not started")
+
+// ErrNotRunning is returned by methods that need a live process, such as
+// Pause, when the process has not been started or has already exited.
+var ErrNotRunning = errors.New("process not running")
+
+// ErrNoInputPipe is returned by Write and the other input methods when
+// there is nothing to write to: the process has not been started, has been
+// stopped, was given Config.Stdin, or its input was closed with CloseStdin
+// or ShutdownWrite.
+var ErrNoInputPipe = errors.New("no input pipe available")
+
+// ErrRuntimeExceeded is returned when starting a process whose
+// Config.MaxTotalRuntime budget has already been used up.
+var ErrRuntimeExceeded = errors.New("maximum total runtime exceeded")
+
+// ErrCommandNotFound is returned when starting a process whose executable
+// does not exist or cannot be found in PATH.
+var ErrCommandNotFound = errors.New("command not found")
+
+// ErrAlreadyStarted is returned when starting a ProcessManager that has
+// already been started. Use Restart to run the command again.
+var ErrAlreadyStarted = errors.New("process already started")
+
+// ErrTimeout is returned by Wait when the process was stopped because it
+// ran past Config.Timeout.
+var ErrTimeout = errors.New("process timed out")
+
+// ErrWaitTimeout is returned by WaitWithTimeout when the process is still
+// running at the deadline.
+var ErrWaitTimeout = errors.New("timed out waiting for process to exit")
+
 // New creates a new ProcessManager for the given command and arguments.
 // It uses default environment variables and provides no initial handlers.
 func New(command string, args ...string) *ProcessManager {
-	ctx, cancel := context.WithCancel(context.Background())
-	cmd := exec.CommandContext(ctx, command, args...)
+	return NewWithContext(context.Background(), command, args...)
+}
+
+// NewShell creates a ProcessManager that runs cmdline through a shell, as
+// with Config.Shell, for a full command line such as "ls | grep foo". See
+// Config.Shell for how the shell is chosen and what that means for quoting.
+func NewShell(cmdline string) *ProcessManager {
+	return NewWithConfig(Config{Command: cmdline, Shell: true})
+}
+
+// NewWithContext is like New, but the process is killed as soon as ctx is
+// done. Stop keeps working independently of ctx.
+func NewWithContext(ctx context.Context, command string, args ...string) *ProcessManager {
+	procCtx, cancel := context.WithCancel(ctx)
+	cmd := exec.CommandContext(procCtx, command, args...)
 	cmd.Env = os.Environ()
 
 	return &ProcessManager{
-		cmd:    cmd,
-		ctx:    ctx,
-		cancel: cancel,
+		cmd:       cmd,
+		parentCtx: ctx,
+		ctx:       procCtx,
+		cancel:    cancel,
+		killGroup: true,
 	}
 }
 
 // NewWithConfig creates a ProcessManager using the provided Config.
 func NewWithConfig(cfg Config) *ProcessManager {
-	ctx, cancel := context.WithCancel(context.Background())
-	cmd := exec.CommandContext(ctx, cfg.Command, cfg.Args...)
+	parent := cfg.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	var cmd *exec.Cmd
+	if cfg.Shell {
+		cmd = shellCommand(ctx, cfg.Command, cfg.Args)
+	} else {
+		cmd = exec.CommandContext(ctx, cfg.Command, cfg.Args...)
+	}
 
 	if len(cfg.Env) > 0 {
 		cmd.Env = append(os.Environ(), cfg.Env...)
 	} else {
 		cmd.Env = os.Environ()
 	}
+	cmd.Dir = cfg.Dir
+	if err := setCredential(cmd, cfg.Credential); err != nil {
+		cmd.Err = err
+	}
 
-	return &ProcessManager{
-		cmd:      cmd,
-		ctx:      ctx,
-		cancel:   cancel,
-		onOutput: cfg.OnOutput,
-		onError:  cfg.OnError,
+	var scrollback *ringBuffer
+	if cfg.ScrollbackBytes > 0 {
+		scrollback = newRingBuffer(cfg.ScrollbackBytes)
+	}
+
+	var asyncBufferSize int
+	if cfg.AsyncHandlers {
+		asyncBufferSize = cfg.AsyncBufferSize
+		if asyncBufferSize <= 0 {
+			asyncBufferSize = DefaultAsyncBufferSize
+		}
+	}
+
+	var logFile *rotatingLog
+	if cfg.LogFile != "" {
+		logFile = newRotatingLog(cfg.LogFile, cfg.LogMaxBytes, cfg.LogMaxBackups)
+	}
+
+	var winsize *pty.Winsize
+	if cfg.Rows > 0 && cfg.Cols > 0 {
+		winsize = &pty.Winsize{Rows: cfg.Rows, Cols: cfg.Cols}
+	}
+
+	var scr *screen
+	if cfg.TrackScreen {
+		scr = newScreen(defaultScreenRows, defaultScreenCols)
+		if winsize != nil {
+			scr.Resize(int(winsize.Rows), int(winsize.Cols))
+		}
+	}
+
+	p := &ProcessManager{
+		cmd:       cmd,
+		parentCtx: parent,
+		ctx:       ctx,
+		cancel:    cancel,
+		handlers:  [2][]OutputHandler{handlerList(cfg.OnOutput), handlerList(cfg.OnError)},
+		readDelay: cfg.ReadDelay,
+
+		readBufferSize:  cfg.ReadBufferSize,
+		combineOutput:   cfg.CombineOutput,
+		preferPTY:       cfg.PreferPTY,
+		beforeStart:     cfg.BeforeStart,
+		extraFiles:      cfg.ExtraFiles,
+		killGroup:       cfg.KillProcessGroup == nil || *cfg.KillProcessGroup,
+		queryTerminator: cfg.QueryTerminator,
+		pauseWhenSlow:   cfg.PauseWhenSlow,
+		maxTotalRuntime: cfg.MaxTotalRuntime,
+		timeout:         cfg.Timeout,
+		scrollback:      scrollback,
+		onHandlerPanic:  cfg.OnHandlerPanic,
+		restartOnCodes:  cfg.RestartOnCodes,
+		restartDelay:    cfg.RestartDelay,
+		onExit:          cfg.OnExit,
+		onClose:         cfg.OnClose,
+		stdin:           cfg.Stdin,
+
+		stripANSI:           cfg.StripANSI,
+		suppressEcho:        cfg.SuppressEcho,
+		onRawOutput:         cfg.OnRawOutput,
+		onInput:             cfg.OnInput,
+		lineEnding:          cfg.LineEnding,
+		keyDelay:            cfg.KeyDelay,
+		winsize:             winsize,
+		inputCoalesceWindow: cfg.InputCoalesceWindow,
+		screen:              scr,
+		asyncBufferSize:     asyncBufferSize,
+		flushMode:           cfg.FlushMode,
+		flushInterval:       cfg.FlushInterval,
+		writers:             [2]io.Writer{cfg.OutputWriter, cfg.ErrorWriter},
+		onWriterError:       cfg.OnWriterError,
+		logFile:             logFile,
+		collapseProgress:    cfg.CollapseProgress,
+	}
+	p.SetWriteRateLimit(cfg.WriteRateLimit)
+	p.initCollapsers()
+	return p
+}
+
+// Clone returns a new, unstarted ProcessManager for the same command, with
+// the same arguments, environment, working directory, handlers, window size
+// and other settings as p, including changes made since p was created. It
+// is convenient for starting several identical processes, such as a pool of
+// REPL workers. The clone has its own context, derived from the same parent
+// context as p, and its own output buffers and runtime budget.
+//
+// Writers set with SetOutputWriter and SetEventWriter and the log file are
+// shared with p. Buffers passed to CaptureTo, LineChannel subscriptions,
+// recordings and Config.Stdin, which can only be read once, are not carried
+// over.
+func (p *ProcessManager) Clone() *ProcessManager {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(p.parentCtx)
+	c := &ProcessManager{
+		cmd:       copyCmd(ctx, p.cmd, p.baseAttrLocked()),
+		parentCtx: p.parentCtx,
+		ctx:       ctx,
+		cancel:    cancel,
+		readDelay: p.readDelay,
+
+		readBufferSize:  p.readBufferSize,
+		combineOutput:   p.combineOutput,
+		preferPTY:       p.preferPTY,
+		beforeStart:     p.beforeStart,
+		extraFiles:      p.extraFiles,
+		killGroup:       p.killGroup,
+		queryTerminator: p.queryTerminator,
+		pauseWhenSlow:   p.pauseWhenSlow,
+		maxTotalRuntime: p.maxTotalRuntime,
+		timeout:         p.timeout,
+		onHandlerPanic:  p.onHandlerPanic,
+		restartOnCodes:  p.restartOnCodes,
+		restartDelay:    p.restartDelay,
+		onExit:          p.onExit,
+		onClose:         p.onClose,
+
+		stripANSI:           p.stripANSI,
+		suppressEcho:        p.suppressEcho,
+		onRawOutput:         p.onRawOutput,
+		onInput:             p.onInput,
+		lineEnding:          p.lineEnding,
+		keyDelay:            p.keyDelay,
+		inputCoalesceWindow: p.inputCoalesceWindow,
+		asyncBufferSize:     p.asyncBufferSize,
+		flushMode:           p.flushMode,
+		flushInterval:       p.flushInterval,
+		onWriterError:       p.onWriterError,
+		logFile:             p.logFile,
+		collapseProgress:    p.collapseProgress,
+		softCtx:             p.softCtx,
+	}
+	c.initCollapsers()
+	for s := range p.handlers {
+		c.handlers[s] = slices.Clone(p.handlers[s])
+	}
+	if p.winsize != nil {
+		ws := *p.winsize
+		c.winsize = &ws
 	}
+	if p.scrollback != nil {
+		c.scrollback = newRingBuffer(len(p.scrollback.buf))
+	}
+	if p.screen != nil {
+		c.screen = newScreen(defaultScreenRows, defaultScreenCols)
+		c.resizeScreenLocked()
+	}
+
+	c.limiter.rate.Store(p.limiter.rate.Load())
+
+	p.captureMu.Lock()
+	c.writers = p.writers
+	c.eventWriter = p.eventWriter
+	p.captureMu.Unlock()
+	return c
 }
 
-// SetOutputHandler sets or updates the callback for stdout data.
+// SetOutputHandler sets or updates the callback for stdout data, replacing
+// any handlers added with AddOutputHandler. A nil handler removes them all.
 func (p *ProcessManager) SetOutputHandler(handler OutputHandler) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.onOutput = handler
+	p.handlers[StdOut] = handlerList(handler)
 }
 
-// SetErrorHandler sets or updates the callback for stderr data.
+// SetErrorHandler sets or updates the callback for stderr data, replacing
+// any handlers added with AddErrorHandler. A nil handler removes them all.
 func (p *ProcessManager) SetErrorHandler(handler OutputHandler) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.onError = handler
+	p.handlers[StdErr] = handlerList(handler)
+}
+
+// SetHandler sets a single callback for both stdout and stderr data,
+// replacing the handlers for both, so that output can be told apart by its
+// origin in one place. In PTY mode, where stderr is merged into stdout, the
+// stream is always StdOut. A nil handler removes all handlers.
+func (p *ProcessManager) SetHandler(handler StreamHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if handler == nil {
+		p.handlers = [2][]OutputHandler{}
+		return
+	}
+	for _, s := range []Stream{StdOut, StdErr} {
+		s := s
+		p.handlers[s] = []OutputHandler{func(data []byte) { handler(s, data) }}
+	}
+}
+
+// AddOutputHandler adds a callback for stdout data alongside any existing
+// ones, so output can for example be printed and collected at the same
+// time. Handlers are called one after another, in the order they were
+// added, on the goroutine that reads the output; a slow handler delays the
+// ones after it and blocks further reads until it returns, unless
+// Config.AsyncHandlers is set.
+func (p *ProcessManager) AddOutputHandler(handler OutputHandler) {
+	if handler == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[StdOut] = append(p.handlers[StdOut], handler)
+}
+
+// AddErrorHandler is like AddOutputHandler for stderr data.
+func (p *ProcessManager) AddErrorHandler(handler OutputHandler) {
+	if handler == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[StdErr] = append(p.handlers[StdErr], handler)
+}
+
+// ClearOutputHandlers removes every stdout and stderr handler. Output is
+// still recorded for Expect, LineChannel and the other readers.
+func (p *ProcessManager) ClearOutputHandlers() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers = [2][]OutputHandler{}
+}
+
+// handlerList returns a handler list holding just handler, or none if it is
+// nil.
+func handlerList(handler OutputHandler) []OutputHandler {
+	if handler == nil {
+		return nil
+	}
+	return []OutputHandler{handler}
+}
+
+// handlersFor returns the handlers currently registered for s. The list is
+// never modified in place, so it can be used after p.mu is released.
+func (p *ProcessManager) handlersFor(s Stream) []OutputHandler {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.handlers[s]
 }
 
 // StartWithPTY starts the process attached to a pseudo-terminal (PTY).
 // This is required for interactive programs like shells, Python REPL, etc.
+// On Windows the PTY is a pseudo console (ConPTY), available since Windows
+// 10 1809.
 func (p *ProcessManager) StartWithPTY() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	var err error
-	p.pty, err = pty.Start(p.cmd)
-	if err != nil {
-		return fmt.Errorf("start PTY failed: %w", err)
+	if err := p.checkStartLocked(); err != nil {
+		return err
 	}
-	p.running = true
-
-	go p.readOutput()
-	return nil
+	p.saveAttrLocked()
+	return p.startPTYLocked()
 }
 
 // StartWithPipes starts the process using standard OS pipes for stdin/stdout/stderr.
@@ -135,151 +879,1321 @@ func (p *ProcessManager) StartWithPipes() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	stdin, err := p.cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("create stdin pipe: %w", err)
+	if err := p.checkStartLocked(); err != nil {
+		return err
 	}
-	p.stdinPipe = stdin
+	p.saveAttrLocked()
+	return p.startPipesLocked()
+}
 
-	stdout, err := p.cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("create stdout pipe: %w", err)
+// Start starts the process in pipes mode, or in PTY mode if
+// Config.PreferPTY is set, and falls back to the other mode if that fails.
+// Commands that only need a terminal for their output formatting can then
+// be run the same way everywhere, including where PTYs are unavailable.
+//
+// The fallback is tried whenever the first mode fails to start, except when
+// the command does not exist (ErrCommandNotFound) or the context is done,
+// since the other mode would fail in the same way. Start itself fails with
+// ErrAlreadyStarted or ErrRuntimeExceeded before trying either mode. If both
+// modes fail, both errors are returned.
+func (p *ProcessManager) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkStartLocked(); err != nil {
+		return err
 	}
+	p.saveAttrLocked()
 
-	stderr, err := p.cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("create stderr pipe: %w", err)
+	first, second := modePipes, modePTY
+	if p.preferPTY {
+		first, second = modePTY, modePipes
+	}
+	err := p.startModeLocked(first)
+	var hookErr *beforeStartError
+	if err == nil || errors.Is(err, ErrCommandNotFound) || errors.As(err, &hookErr) || p.ctx.Err() != nil {
+		return err
 	}
 
-	if err := p.cmd.Start(); err != nil {
-		return fmt.Errorf("start command: %w", err)
+	// A failed exec.Cmd cannot be started again.
+	p.cmd = p.newCmdLocked()
+	if fallbackErr := p.startModeLocked(second); fallbackErr != nil {
+		return errors.Join(err, fallbackErr)
 	}
-	p.running = true
+	return nil
+}
+
+// startPipesLocked starts the process in pipes mode. The caller must hold
+// p.mu.
+func (p *ProcessManager) startPipesLocked() error {
+	stdout, stderr, err := p.startPipes()
+	if err != nil {
+		return err
+	}
+	p.mode = modePipes
+	if p.stdin != nil {
+		go feedStdin(p.stdinPipe, p.stdin)
+		p.stdinPipe = nil
+	}
+	if stderr == nil {
+		p.outPipes = []*os.File{stdout}
+		p.started(1)
+		go p.readFromReader(stdout, StdOut)
+		return nil
+	}
+	p.outPipes = []*os.File{stdout, stderr}
+	p.started(2)
 
-	go p.readFromReader(stdout, p.onOutput)
-	go p.readFromReader(stderr, p.onError)
+	go p.readFromReader(stdout, StdOut)
+	go p.readFromReader(stderr, StdErr)
 	return nil
 }
 
-// readOutput is an internal goroutine that reads from the PTY.
-func (p *ProcessManager) readOutput() {
-	buf := make([]byte, 4096)
-	for {
-		n, err := p.pty.Read(buf)
-		if n > 0 {
-			data := make([]byte, n)
-			copy(data, buf[:n])
+// startPipes connects the process to OS pipes and starts it, returning the
+// read ends of stdout and stderr. When output is combined both streams share
+// one pipe and stderr is nil. The caller must hold p.mu.
+func (p *ProcessManager) startPipes() (stdout, stderr *os.File, err error) {
+	if p.extraFiles != nil {
+		p.cmd.ExtraFiles = p.extraFiles
+	}
+	if err := p.runBeforeStartLocked(); err != nil {
+		return nil, nil, err
+	}
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("create stdin pipe: %w", err)
+	}
 
-			p.mu.Lock()
-			handler := p.onOutput
-			p.mu.Unlock()
+	// Use our own pipes rather than cmd.StdoutPipe so that reaping the
+	// process in the background does not close them before all output has
+	// been read.
+	stdout, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("create stdout pipe: %w", err)
+	}
+	p.cmd.Stdout = stdoutW
+	p.cmd.Stderr = stdoutW
 
-			if handler != nil {
-				handler(data)
-			}
-		}
+	// Giving the child the same pipe for both streams, as 2>&1 does, keeps
+	// their writes in order.
+	stderrW := stdoutW
+	if !p.combineOutput {
+		stderr, stderrW, err = os.Pipe()
 		if err != nil {
-			p.mu.Lock()
-			handler := p.onError
-			p.mu.Unlock()
+			stdout.Close()
+			stdoutW.Close()
+			return nil, nil, fmt.Errorf("create stderr pipe: %w", err)
+		}
+		p.cmd.Stderr = stderrW
+	}
 
-			// Check for EIO on Linux which indicates PTY closed
-			if err != io.EOF && !errors.Is(err, syscall.EIO) && handler != nil {
-				handler([]byte(fmt.Sprintf("\n[Read Error]: %v\n", err)))
-			}
-			break
+	if p.killGroup {
+		setProcessGroup(p.cmd)
+	}
+	p.prepareCmdLocked()
+	err = p.cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdout.Close()
+		if stderr != nil {
+			stderr.Close()
 		}
+		return nil, nil, p.startError("start command", err)
 	}
+	p.stdinPipe = stdin
+	return stdout, stderr, nil
 }
 
-// readFromReader is an internal helper to stream data from a reader to a handler.
-func (p *ProcessManager) readFromReader(r io.Reader, handler OutputHandler) {
-	buf := make([]byte, 4096)
-	for {
-		n, err := r.Read(buf)
-		if n > 0 {
-			data := make([]byte, n)
-			copy(data, buf[:n])
-			if handler != nil {
-				handler(data)
-			}
+// started records that a run of the process has begun with the given
+// number of output streams and launches the goroutine that reaps it. A run
+// that follows an automatic relaunch continues the current session, so
+// Wait, Expect and LineChannel carry on across it. The caller must hold p.mu.
+func (p *ProcessManager) started(readers int) {
+	if p.done != nil && !isClosed(p.done) {
+		p.addReaders(readers)
+	} else {
+		p.done = make(chan struct{})
+		p.state = nil
+		p.linesClosed = false
+		p.resetOutput(readers)
+		p.startHandlerQueueLocked()
+		p.startTimeoutLocked(p.done)
+
+		if p.softCtx != nil {
+			go p.watchSoftCancel(p.softCtx, p.done)
 		}
-		if err != nil {
-			if err != io.EOF && handler != nil {
-				handler([]byte(fmt.Sprintf("[Read Error]: %v\n", err)))
+	}
+
+	p.running = true
+	p.stderrTail = newRingBuffer(stderrTailSize)
+	p.echo.reset()
+	p.startTime = time.Now()
+	p.firstByteTime = time.Time{}
+	p.exitTime = time.Time{}
+	p.argv = append([]string(nil), p.cmd.Args...)
+	p.environ = p.cmd.Environ()
+	go p.wait(p.cmd, p.done)
+
+	if p.maxTotalRuntime > 0 {
+		p.runtimeTimer = time.AfterFunc(p.maxTotalRuntime-p.totalRuntime, func() {
+			p.StopGracefully(DefaultGracePeriod)
+		})
+	}
+}
+
+// isClosed reports whether ch has been closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// startError describes a failure to start p.cmd. A missing executable is
+// reported as ErrCommandNotFound, so callers can tell a bad command apart
+// from, say, a failure to allocate a PTY.
+//
+// The child reports a missing working directory with the same ENOENT as a
+// missing executable, so that case is told apart by checking both.
+func (p *ProcessManager) startError(what string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrCommandNotFound, p.cmd.Args[0])
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) && pathErr.Op == "fork/exec" && errors.Is(err, fs.ErrNotExist) {
+		if dir := p.cmd.Dir; dir != "" {
+			if _, statErr := os.Stat(dir); statErr != nil {
+				return fmt.Errorf("%s: working directory: %w", what, statErr)
 			}
-			break
+		}
+		if _, statErr := os.Stat(p.cmd.Path); statErr != nil {
+			return fmt.Errorf("%w: %s", ErrCommandNotFound, p.cmd.Args[0])
 		}
 	}
+	return fmt.Errorf("%s: %w", what, err)
 }
 
-// Write sends raw bytes to the process's standard input.
-func (p *ProcessManager) Write(data []byte) (n int, err error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// beforeStartError is an error returned by Config.BeforeStart.
+type beforeStartError struct {
+	err error
+}
 
-	if p.pty != nil {
-		return p.pty.Write(data)
+func (e *beforeStartError) Error() string { return e.err.Error() }
+func (e *beforeStartError) Unwrap() error { return e.err }
+
+// runBeforeStartLocked calls Config.BeforeStart, if set, with the command
+// about to be launched. The caller must hold p.mu.
+func (p *ProcessManager) runBeforeStartLocked() error {
+	if p.beforeStart == nil {
+		return nil
 	}
-	if p.stdinPipe != nil {
-		return p.stdinPipe.Write(data)
+	if err := p.beforeStart(p.cmd); err != nil {
+		return &beforeStartError{err}
 	}
-	return 0, fmt.Errorf("no input pipe available")
+	return nil
 }
 
-// WriteString sends a string to the process's standard input.
-func (p *ProcessManager) WriteString(s string) error {
-	_, err := p.Write([]byte(s))
-	return err
+// prepareCmdLocked makes cancelling the context kill the process the same
+// way Stop does. The caller must hold p.mu.
+func (p *ProcessManager) prepareCmdLocked() {
+	cmd := p.cmd
+	killGroup := p.killGroup
+	cmd.Cancel = func() error {
+		if killGroup {
+			signalGroup(cmd.Process, os.Kill)
+		}
+		return cmd.Process.Kill()
+	}
 }
 
-// Writef formats a string and sends it to the process's standard input.
-func (p *ProcessManager) Writef(format string, args ...any) error {
-	_, err := p.Write([]byte(fmt.Sprintf(format, args...)))
-	return err
+// checkStartLocked reports whether the process may be started. An exec.Cmd
+// runs only once, so a ProcessManager that has already been started is
+// rejected with ErrAlreadyStarted, even once it has been stopped; one whose
+// runtime budget is used up is rejected too. The caller must hold p.mu.
+func (p *ProcessManager) checkStartLocked() error {
+	if p.running {
+		return ErrAlreadyStarted
+	}
+	if p.done != nil {
+		return fmt.Errorf("%w: the process has exited, use Restart to run it again", ErrAlreadyStarted)
+	}
+	return p.checkRuntimeBudget()
 }
 
-// Writeln sends a string followed by a newline to the process's standard input.
-func (p *ProcessManager) Writeln(s string) error {
-	return p.WriteString(s + "\n")
+// checkRuntimeBudget reports ErrRuntimeExceeded if Config.MaxTotalRuntime
+// has been used up. The caller must hold p.mu.
+func (p *ProcessManager) checkRuntimeBudget() error {
+	if p.maxTotalRuntime > 0 && p.totalRuntime >= p.maxTotalRuntime {
+		return ErrRuntimeExceeded
+	}
+	return nil
 }
 
-// IsRunning returns true if the process is currently active.
-func (p *ProcessManager) IsRunning() bool {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.running
-}
+// wait reaps one run of the process. Unless the process is relaunched, it
+// publishes the result to Wait callers and releases the session's hold on
+// the output streams.
+func (p *ProcessManager) wait(cmd *exec.Cmd, done chan struct{}) {
+	err := cmd.Wait()
 
-// Stop terminates the process and closes associated pipes or PTY.
-func (p *ProcessManager) Stop() error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.exitTime = time.Now()
+	p.totalRuntime += p.exitTime.Sub(p.startTime)
+	if p.runtimeTimer != nil {
+		p.runtimeTimer.Stop()
+		p.runtimeTimer = nil
+	}
+
+	var relaunchErr error
+	if p.shouldRelaunchLocked(cmd) && p.awaitRelaunchLocked() {
+		if relaunchErr = p.relaunchLocked(); relaunchErr == nil {
+			p.mu.Unlock()
+			return
+		}
+		// p.cmd was never started; keep the run that ended as the current
+		// one, for ExitCode, Pid and the like.
+		p.cmd = cmd
+	}
 
-	p.cancel()
 	p.running = false
+	p.paused = false
+	p.state = cmd.ProcessState
+	if p.timeoutTimer != nil {
+		p.timeoutTimer.Stop()
+		p.timeoutTimer = nil
+	}
+	timedOut := p.timedOut
+	p.mu.Unlock()
 
-	if p.pty != nil {
-		p.pty.Close()
+	if timedOut {
+		err = fmt.Errorf("%w after %v", ErrTimeout, p.timeout)
+	} else {
+		err = p.processError(cmd, err)
 	}
-	if p.stdinPipe != nil {
-		p.stdinPipe.Close()
+	if relaunchErr != nil {
+		err = errors.Join(err, fmt.Errorf("relaunch: %w", relaunchErr))
 	}
+	p.mu.Lock()
+	p.waitErr = err
+	p.mu.Unlock()
 
-	if p.cmd.Process != nil {
-		return p.cmd.Process.Kill()
+	close(done)
+	p.readerDone()
+
+	if p.onExit != nil {
+		p.onExit(err)
 	}
-	return nil
 }
 
-// Wait blocks until the managed process exits.
-func (p *ProcessManager) Wait() error {
-	return p.cmd.Wait()
+// startTimeoutLocked arms Config.Timeout, if set, for the session ending
+// when done is closed. The caller must hold p.mu.
+func (p *ProcessManager) startTimeoutLocked(done chan struct{}) {
+	if p.timeoutTimer != nil {
+		p.timeoutTimer.Stop()
+		p.timeoutTimer = nil
+	}
+	p.timedOut = false
+	if p.timeout <= 0 {
+		return
+	}
+	p.timeoutTimer = time.AfterFunc(p.timeout, func() {
+		p.mu.Lock()
+		// the session may have ended, or been replaced by a Restart,
+		// while the timer fired
+		if p.done != done || isClosed(done) || p.stopped {
+			p.mu.Unlock()
+			return
+		}
+		p.timedOut = true
+		p.mu.Unlock()
+		p.Stop()
+	})
 }
 
-// Pid returns the process ID of the managed process, or -1 if not started.
-func (p *ProcessManager) Pid() int {
-	if p.cmd.Process != nil {
+// shouldRelaunchLocked reports whether the run of cmd that just ended
+// should be followed by another one according to Config.RestartOnCodes.
+// The caller must hold p.mu.
+func (p *ProcessManager) shouldRelaunchLocked(cmd *exec.Cmd) bool {
+	if len(p.restartOnCodes) == 0 || p.stopped || p.ctx.Err() != nil {
+		return false
+	}
+	if p.mode == modePipesRaw || p.checkRuntimeBudget() != nil {
+		return false
+	}
+	return slices.Contains(p.restartOnCodes, cmd.ProcessState.ExitCode())
+}
+
+// awaitRelaunchLocked holds back a relaunch until Config.RestartDelay has
+// passed since the run that just ended was started, and reports whether it
+// should still go ahead. p.mu is released while waiting; the process counts
+// as not running meanwhile, so that Stop does not signal its reaped PID. The
+// caller must hold p.mu.
+func (p *ProcessManager) awaitRelaunchLocked() bool {
+	delay := p.restartDelay
+	if delay <= 0 {
+		delay = DefaultRestartDelay
+	}
+	delay -= p.exitTime.Sub(p.startTime)
+	if delay <= 0 {
+		return true
+	}
+
+	p.running = false
+	ctx := p.ctx
+	p.mu.Unlock()
+	timer := time.NewTimer(delay)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+	p.mu.Lock()
+	return !p.stopped && ctx.Err() == nil
+}
+
+// relaunchLocked starts the command again in the mode it was last started
+// in. The caller must hold p.mu.
+func (p *ProcessManager) relaunchLocked() error {
+	p.cmd = p.newCmdLocked()
+	return p.startModeLocked(p.mode)
+}
+
+// startModeLocked starts p.cmd in the given mode. The caller must hold p.mu.
+func (p *ProcessManager) startModeLocked(mode startMode) error {
+	switch mode {
+	case modePTY:
+		return p.startPTYLocked()
+	case modeSplitPTY:
+		return p.startSplitPTYLocked()
+	case modePipes:
+		return p.startPipesLocked()
+	}
+	return fmt.Errorf("cannot relaunch a process started in this mode")
+}
+
+// newCmdLocked returns an unstarted copy of the current command, including
+// any changes made to its path, environment or attributes, for launching it
+// again. ExtraFiles are kept only for a relaunch in pipes mode. The caller
+// must hold p.mu.
+func (p *ProcessManager) newCmdLocked() *exec.Cmd {
+	cmd := copyCmd(p.ctx, p.cmd, p.baseAttrLocked())
+	if p.mode == modePipes {
+		cmd.ExtraFiles = p.cmd.ExtraFiles
+	}
+	return cmd
+}
+
+// saveAttrLocked records the command's SysProcAttr as the caller left it,
+// before starting the process adjusts it for the mode: a PTY start sets
+// Setsid and Setctty, which make a later start in pipes mode fail, and a
+// pipes start sets Setpgid, which a PTY start cannot combine with Setsid.
+// The caller must hold p.mu.
+func (p *ProcessManager) saveAttrLocked() {
+	p.baseAttr = nil
+	if p.cmd.SysProcAttr != nil {
+		attr := *p.cmd.SysProcAttr
+		p.baseAttr = &attr
+	}
+	p.attrSaved = true
+}
+
+// baseAttrLocked returns the SysProcAttr recorded by saveAttrLocked, or
+// the command's own if the process has not been started. The caller must
+// hold p.mu.
+func (p *ProcessManager) baseAttrLocked() *syscall.SysProcAttr {
+	if p.attrSaved {
+		return p.baseAttr
+	}
+	return p.cmd.SysProcAttr
+}
+
+// copyCmd returns an unstarted copy of old bound to ctx, with a copy of
+// attr as its SysProcAttr. ExtraFiles are left out, as they only apply in
+// pipes mode.
+func copyCmd(ctx context.Context, old *exec.Cmd, attr *syscall.SysProcAttr) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, old.Path)
+	cmd.Path = old.Path
+	cmd.Err = old.Err
+	cmd.Args = old.Args
+	cmd.Env = old.Env
+	cmd.Dir = old.Dir
+	if attr != nil {
+		attr := *attr
+		cmd.SysProcAttr = &attr
+	}
+	return cmd
+}
+
+// readOutput is an internal goroutine that reads from the PTY.
+// It reads from f rather than p.pty, which Stop, Restart and relaunches
+// replace under p.mu. Both Stop and readOutput close f, which is safe for an
+// *os.File; a read interrupted by Stop ends with os.ErrClosed. The PTY is
+// also closed once the process has gone and it has been drained.
+func (p *ProcessManager) readOutput(f *os.File) {
+	defer p.readerDone()
+	defer f.Close()
+
+	buf := p.newReadBuffer()
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			if p.suppressEcho {
+				data = p.echo.filter(data)
+			}
+			if len(data) > 0 {
+				p.record(StdOut, data)
+				p.deliver(StdOut, data)
+			}
+		}
+		if err != nil {
+			// EIO on Linux indicates the PTY closed, and ErrClosed a PTY
+			// closed by Stop
+			reason := closeReason(err)
+			if reason == ClosedError {
+				p.deliver(StdErr, []byte(fmt.Sprintf("\n[Read Error]: %v\n", err)))
+			}
+			p.streamClosed(reason)
+			break
+		}
+	}
+}
+
+// readFromReader is an internal helper to stream data from a reader to the
+// handlers for s.
+func (p *ProcessManager) readFromReader(r io.ReadCloser, s Stream) {
+	defer p.readerDone()
+	defer r.Close()
+
+	buf := p.newReadBuffer()
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			p.record(s, data)
+			p.deliver(s, data)
+		}
+		if err != nil {
+			// A PTY used for stderr reports EIO once it is closed, and
+			// Stop may close the read end under us.
+			reason := closeReason(err)
+			if reason == ClosedError {
+				p.deliver(s, []byte(fmt.Sprintf("[Read Error]: %v\n", err)))
+			}
+			p.streamClosed(reason)
+			break
+		}
+	}
+}
+
+// CloseReason describes how an output stream of the process ended.
+//
+// A PTY presents the end of a session differently per platform: on Linux,
+// reading it fails with EIO once the process and everything it started have
+// closed the terminal, so a clean exit in PTY mode is reported as ClosedEIO,
+// while macOS and the BSDs usually report a plain end of file, ClosedEOF.
+// Pipes, and ConPTY on Windows, always end with ClosedEOF. Either way
+// ClosedError is the one that indicates a real failure.
+type CloseReason int
+
+const (
+	// ClosedEOF means the stream reached end of file.
+	ClosedEOF CloseReason = iota
+	// ClosedEIO means reading the PTY failed with EIO, how Linux reports
+	// that the other side of the terminal has been closed.
+	ClosedEIO
+	// ClosedStopped means the stream was closed by Stop or Restart.
+	ClosedStopped
+	// ClosedError means reading failed unexpectedly. The error is also
+	// passed to the stderr handlers as a "[Read Error]" message.
+	ClosedError
+)
+
+// String returns a short lowercase name for the reason, such as "eof".
+func (r CloseReason) String() string {
+	switch r {
+	case ClosedEOF:
+		return "eof"
+	case ClosedEIO:
+		return "eio"
+	case ClosedStopped:
+		return "stopped"
+	default:
+		return "error"
+	}
+}
+
+// closeReason classifies the error that ended a read loop.
+func closeReason(err error) CloseReason {
+	switch {
+	case err == io.EOF:
+		return ClosedEOF
+	case errors.Is(err, syscall.EIO):
+		return ClosedEIO
+	case errors.Is(err, os.ErrClosed):
+		return ClosedStopped
+	default:
+		return ClosedError
+	}
+}
+
+// streamClosed reports the end of an output stream to OnClose.
+func (p *ProcessManager) streamClosed(reason CloseReason) {
+	if p.onClose != nil {
+		p.onClose(reason)
+	}
+}
+
+// newReadBuffer allocates a buffer for one output read loop.
+func (p *ProcessManager) newReadBuffer() []byte {
+	if p.readBufferSize <= 0 {
+		return make([]byte, DefaultReadBufferSize)
+	}
+	return make([]byte, p.readBufferSize)
+}
+
+// record performs the bookkeeping shared by both read loops for a chunk of
+// output before it is handed to a handler.
+func (p *ProcessManager) record(s Stream, data []byte) {
+	if p.readDelay > 0 {
+		time.Sleep(p.readDelay)
+	}
+	p.bytesRead[s].Add(int64(len(data)))
+	p.appendOutput(data)
+	if p.scrollback != nil {
+		p.scrollback.Write(data)
+	}
+	if p.screen != nil {
+		p.screen.Write(data)
+	}
+	p.capture(s, data)
+	p.writeEvent(s, data)
+	p.writeLog(data)
+	p.recordCast(data)
+	p.feedLines(s, data)
+
+	p.mu.Lock()
+	if p.firstByteTime.IsZero() {
+		p.firstByteTime = time.Now()
+	}
+	if s == StdErr && p.stderrTail != nil {
+		p.stderrTail.Write(data)
+	}
+	p.mu.Unlock()
+}
+
+// deliver passes a chunk of output from s on towards its handlers, holding
+// it back first if FlushMode batches output.
+func (p *ProcessManager) deliver(s Stream, data []byte) {
+	if p.flushMode != FlushImmediate {
+		p.batch(s, data)
+		return
+	}
+	p.dispatch(s, data)
+}
+
+// dispatch passes a chunk of output from s to its handlers, or queues it for
+// them when AsyncHandlers is set.
+func (p *ProcessManager) dispatch(s Stream, data []byte) {
+	p.mu.Lock()
+	queue := p.handlerQueue
+	p.mu.Unlock()
+
+	if queue != nil {
+		queue <- handlerCall{s, data}
+		return
+	}
+	p.handle(s, data)
+}
+
+// handle calls the handlers for s with a chunk of output. When PauseWhenSlow
+// is set, the process is suspended for as long as the handlers overrun it.
+func (p *ProcessManager) handle(s Stream, data []byte) {
+	handlers := p.handlersFor(s)
+	if len(handlers) == 0 && p.onRawOutput == nil && !p.stripANSI {
+		return
+	}
+	if p.pauseWhenSlow <= 0 {
+		p.runHandlers(s, handlers, data)
+		return
+	}
+
+	fired := make(chan struct{})
+	timer := time.AfterFunc(p.pauseWhenSlow, func() {
+		defer close(fired)
+		p.slowHandlerStarted()
+	})
+	p.runHandlers(s, handlers, data)
+	if !timer.Stop() {
+		<-fired
+		p.slowHandlerFinished()
+	}
+}
+
+// runHandlers passes a chunk of output from s to OnRawOutput and then to
+// handlers, removing escape sequences for the latter if StripANSI is set.
+func (p *ProcessManager) runHandlers(s Stream, handlers []OutputHandler, data []byte) {
+	if raw := p.onRawOutput; raw != nil {
+		p.callHandler(func(data []byte) { raw(s, data) }, data)
+	}
+	if p.stripANSI {
+		// Strip even without handlers, so that one added later does not
+		// start in the middle of a sequence
+		if data = p.strippers[s].strip(data); len(data) == 0 {
+			return
+		}
+	}
+	p.callHandlers(handlers, data)
+}
+
+// callHandlers invokes each of handlers in turn with data.
+func (p *ProcessManager) callHandlers(handlers []OutputHandler, data []byte) {
+	for _, handler := range handlers {
+		p.callHandler(handler, data)
+	}
+}
+
+// callHandler invokes handler, recovering from any panic so the read loop
+// keeps running. Recovered panics are passed to OnHandlerPanic, or else
+// reported to the stderr handlers.
+func (p *ProcessManager) callHandler(handler OutputHandler, data []byte) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if p.onHandlerPanic != nil {
+			p.onHandlerPanic(r, data)
+			return
+		}
+		p.reportPanic(r)
+	}()
+	handler(data)
+}
+
+// reportPanic passes a description of a recovered handler panic to the
+// stderr handlers. A panic in one of those while reporting is discarded,
+// since reporting it would only panic again.
+func (p *ProcessManager) reportPanic(r any) {
+	msg := []byte(fmt.Sprintf("[Handler Panic]: %v\n", r))
+	for _, handler := range p.handlersFor(StdErr) {
+		func() {
+			defer func() { recover() }()
+			handler(msg)
+		}()
+	}
+}
+
+// slowHandlerStarted suspends the process when the first handler overruns
+// the PauseWhenSlow threshold.
+func (p *ProcessManager) slowHandlerStarted() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.slowHandlers++
+	if p.slowHandlers == 1 && p.running && p.cmd.Process != nil {
+		suspendProcess(p.cmd.Process, p.killGroup)
+	}
+}
+
+// slowHandlerFinished resumes the process once no handler is overrunning
+// the PauseWhenSlow threshold, unless it has been paused with Pause.
+func (p *ProcessManager) slowHandlerFinished() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.slowHandlers--
+	if p.slowHandlers == 0 && !p.paused && p.running && p.cmd.Process != nil {
+		resumeProcess(p.cmd.Process, p.killGroup)
+	}
+}
+
+// ProcessManager is an io.Writer for the process's standard input, so input
+// can be streamed in with io.Copy or fmt.Fprintf.
+var _ io.Writer = (*ProcessManager)(nil)
+
+// Write sends raw bytes to the process's standard input. It implements
+// io.Writer: it either writes all of data or returns the number of bytes
+// written along with a non-nil error, so io.Copy(pm, r) streams r into the
+// process.
+func (p *ProcessManager) Write(data []byte) (n int, err error) {
+	if p.onInput == nil {
+		return p.writeThrottled(data)
+	}
+
+	// Holding inputMu, rather than p.mu, while OnInput runs keeps the
+	// audit trail in the order of the writes without blocking the rest of
+	// the ProcessManager on the callback.
+	p.inputMu.Lock()
+	defer p.inputMu.Unlock()
+
+	n, err = p.writeThrottled(data)
+	if p.inputCoalesceWindow > 0 {
+		// Only what a full batch flushed has been written so far.
+		p.reportInput()
+	} else if n > 0 {
+		p.onInput(data[:n])
+	}
+	return n, err
+}
+
+// write passes data to the process, or to the pending batch when
+// InputCoalesceWindow is set.
+func (p *ProcessManager) write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.inputCoalesceWindow > 0 {
+		return p.coalesceLocked(data)
+	}
+	return p.writeLocked(data)
+}
+
+// writeLocked writes data to the PTY or stdin pipe.
+// The caller must hold p.mu.
+func (p *ProcessManager) writeLocked(data []byte) (int, error) {
+	if p.pty != nil {
+		if p.suppressEcho {
+			// before writing, so the echo cannot arrive first
+			p.echo.expect(data)
+		}
+		return p.pty.Write(data)
+	}
+	if p.stdinPipe != nil {
+		return p.stdinPipe.Write(data)
+	}
+	if p.stdin != nil && p.mode == modePipes {
+		return 0, fmt.Errorf("%w: standard input is read from Config.Stdin", ErrNoInputPipe)
+	}
+	return 0, ErrNoInputPipe
+}
+
+// feedStdin copies r into the process's standard input and closes it at the
+// end of r, so the process sees EOF. Once the process exits, exec closes w
+// and the copy ends.
+func feedStdin(w io.WriteCloser, r io.Reader) {
+	io.Copy(w, r)
+	w.Close()
+}
+
+// WriteString sends a string to the process's standard input.
+func (p *ProcessManager) WriteString(s string) error {
+	_, err := p.Write([]byte(s))
+	return err
+}
+
+// Writef formats a string and sends it to the process's standard input.
+func (p *ProcessManager) Writef(format string, args ...any) error {
+	_, err := p.Write([]byte(fmt.Sprintf(format, args...)))
+	return err
+}
+
+// Writeln sends a string followed by the line ending to the process's
+// standard input. The line ending is "\n" unless changed with
+// Config.LineEnding or SetLineEnding.
+func (p *ProcessManager) Writeln(s string) error {
+	p.mu.Lock()
+	ending := p.lineEnding
+	p.mu.Unlock()
+
+	if ending == "" {
+		ending = "\n"
+	}
+	return p.WriteString(s + ending)
+}
+
+// SetLineEnding sets the line ending Writeln appends. Full-screen programs
+// in a PTY often act on the Enter key, KeyEnter ("\r"), rather than "\n".
+func (p *ProcessManager) SetLineEnding(ending string) error {
+	if ending == "" {
+		return fmt.Errorf("line ending must not be empty")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lineEnding = ending
+	return nil
+}
+
+// CloseStdin signals end of input to the process, so that tools which
+// read until EOF, such as sort and wc, finish and exit by themselves; Wait
+// then returns their natural exit status.
+//
+// In pipes mode the standard input pipe is closed, as with ShutdownWrite,
+// and the process sees EOF once it has read everything written before.
+// A PTY cannot be closed without also losing the output, so in PTY mode the
+// terminal's end-of-file character is typed instead: Ctrl+D, or Ctrl+Z and
+// Enter on Windows. Like a user typing it, that only ends input at the start
+// of a line, or after a second CloseStdin; and programs that put the
+// terminal into raw mode receive it as an ordinary key.
+func (p *ProcessManager) CloseStdin() error {
+	p.mu.Lock()
+	isPTY := p.pty != nil
+	p.mu.Unlock()
+
+	if isPTY {
+		return p.WriteString(ptyEOF)
+	}
+	return p.ShutdownWrite()
+}
+
+// ShutdownWrite half-closes the session: it closes the process's standard
+// input so it sees EOF, while output continues to be read until the process
+// exits. Call Wait afterwards to collect the exit status.
+//
+// This mirrors TCP half-close and is intended for request/response style
+// tools that read all of their input before answering. It is only supported
+// in pipes mode, since closing a PTY would also close its output side.
+func (p *ProcessManager) ShutdownWrite() error {
+	if p.onInput != nil {
+		p.inputMu.Lock()
+		defer p.inputMu.Unlock()
+		defer p.reportInput()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pty != nil {
+		return fmt.Errorf("half-close is not supported on a PTY session")
+	}
+	if p.stdinPipe == nil {
+		return ErrNoInputPipe
+	}
+
+	p.flushInputLocked()
+	err := p.stdinPipe.Close()
+	p.stdinPipe = nil
+	return err
+}
+
+// IsRunning returns true if the process is currently active, that is when
+// State reports StatusRunning or StatusPaused.
+func (p *ProcessManager) IsRunning() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
+
+// Status is the stage of its lifecycle a managed process is in.
+type Status int
+
+const (
+	// StatusNotStarted means the process has not been started yet.
+	StatusNotStarted Status = iota
+	// StatusRunning means the process is running.
+	StatusRunning
+	// StatusPaused means the process is running but has been suspended
+	// with Pause.
+	StatusPaused
+	// StatusExited means the process has exited by itself.
+	StatusExited
+	// StatusKilled means the process was terminated by a signal, including
+	// by Stop, or is being stopped.
+	StatusKilled
+)
+
+// String returns a short lowercase name for the status, such as "running".
+func (s Status) String() string {
+	switch s {
+	case StatusNotStarted:
+		return "not started"
+	case StatusRunning:
+		return "running"
+	case StatusPaused:
+		return "paused"
+	case StatusExited:
+		return "exited"
+	default:
+		return "killed"
+	}
+}
+
+// ProcessState describes the state of a managed process at one moment.
+type ProcessState struct {
+	Status Status
+	// ExitCode is the process's exit code when Status is StatusExited, and
+	// -1 otherwise.
+	ExitCode int
+}
+
+// State returns the current state of the process, for status displays that
+// need more detail than IsRunning. After Restart it describes the new run.
+func (p *ProcessManager) State() ProcessState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.done == nil:
+		return ProcessState{Status: StatusNotStarted, ExitCode: -1}
+	case p.running && p.paused:
+		return ProcessState{Status: StatusPaused, ExitCode: -1}
+	case p.running:
+		return ProcessState{Status: StatusRunning, ExitCode: -1}
+	case p.state != nil && p.state.Exited():
+		return ProcessState{Status: StatusExited, ExitCode: p.state.ExitCode()}
+	default:
+		return ProcessState{Status: StatusKilled, ExitCode: -1}
+	}
+}
+
+// Stop terminates the process and closes associated pipes or PTY.
+// It is safe to call more than once, for example from a deferred call after
+// an explicit one: calls after the first, and calls before the process is
+// started, do nothing and return nil.
+func (p *ProcessManager) Stop() error {
+	// Closed once p.mu is released, as errors go to OnWriterError
+	defer p.closeLog()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done == nil || p.stopped {
+		return nil
+	}
+	p.cancel()
+	running := p.running
+	p.running = false
+	p.stopped = true
+	p.releaseLinesLocked()
+	p.discardInputLocked()
+
+	if p.stopWinch != nil {
+		p.stopWinch()
+		p.stopWinch = nil
+	}
+
+	if p.pty != nil {
+		p.pty.Close()
+	}
+	if p.errPTY != nil {
+		p.errPTY.Close()
+	}
+	if p.stdinPipe != nil {
+		p.stdinPipe.Close()
+	}
+	// Forgotten once closed, so that input methods report ErrNoInputPipe
+	// and SetWindowSize ErrNoPTY
+	p.pty, p.errPTY, p.stdinPipe = nil, nil, nil
+	// Closing the read ends also ends the read loops when a grandchild
+	// still holds the write ends open.
+	for _, f := range p.outPipes {
+		f.Close()
+	}
+
+	if p.cmd.Process != nil {
+		if p.killGroup && running {
+			// Also reaches children left behind by a leader that has
+			// exited but not been reaped yet. Once it has been, its PID,
+			// and so the group's, may belong to another process.
+			signalGroup(p.cmd.Process, os.Kill)
+		}
+		if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wait blocks until the managed process exits.
+// It may be called multiple times and from multiple goroutines. It does not
+// wait for the process's output to be read; see WaitForOutputDrain.
+//
+// If the process ran but did not exit successfully, the error is a
+// *ProcessError carrying its exit code.
+func (p *ProcessManager) Wait() error {
+	p.mu.Lock()
+	done := p.done
+	p.mu.Unlock()
+
+	if done == nil {
+		return ErrNotStarted
+	}
+	<-done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.waitErr
+}
+
+// Done returns a channel that is closed once the process has exited and
+// Wait would no longer block, for use in select statements. It returns nil,
+// which blocks forever, if the process has not been started; after Restart
+// a new channel is used for the new process.
+func (p *ProcessManager) Done() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done
+}
+
+// WaitWithTimeout is like Wait but gives up after d, returning ErrWaitTimeout
+// and leaving the process running. Stopping the process or cancelling its
+// context makes it return as soon as the process has been reaped.
+func (p *ProcessManager) WaitWithTimeout(d time.Duration) error {
+	p.mu.Lock()
+	done := p.done
+	p.mu.Unlock()
+
+	if done == nil {
+		return ErrNotStarted
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		return ErrWaitTimeout
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.waitErr
+}
+
+// Restart stops the process if it is still running and starts the same
+// command again, in the same mode (PTY, split PTY or pipes) it was last
+// started in. It is meant for recovering from a crashed REPL or server
+// without rebuilding the ProcessManager: output handlers and other settings
+// carry over, and changes made with SetDir, SetPath or PrependPath are kept.
+//
+// Restart waits for the old process to exit and its output to drain before
+// starting the new one, so handlers never see the two runs interleaved.
+// Processes started with StartWithPipesRaw cannot be restarted.
+func (p *ProcessManager) Restart() error {
+	p.mu.Lock()
+	mode, done := p.mode, p.done
+	p.mu.Unlock()
+
+	switch mode {
+	case modeNone:
+		return ErrNotStarted
+	case modePipesRaw:
+		return fmt.Errorf("cannot restart a process started with StartWithPipesRaw")
+	}
+
+	if err := p.Stop(); err != nil {
+		return err
+	}
+	<-done
+	p.WaitForOutputDrain()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkRuntimeBudget(); err != nil {
+		return err
+	}
+	p.ctx, p.cancel = context.WithCancel(p.parentCtx)
+	p.cmd = p.newCmdLocked()
+	p.pty, p.errPTY, p.conpty = nil, nil, nil
+	p.stdinPipe, p.outPipes = nil, nil
+	p.stopped = false
+	return p.startModeLocked(mode)
+}
+
+// WaitForOutputDrain blocks until every output stream of the process has
+// reached EOF and the last chunk has been delivered to the handlers. Output
+// can still be in flight when Wait returns, so call this after Wait when the
+// handlers must have seen everything. It returns immediately if the process
+// was never started.
+//
+// Once it returns, every read goroutine has finished: all handler calls,
+// including those queued with AsyncHandlers, have returned, buffers passed
+// to CaptureTo are complete and LineChannel channels are closed, so output
+// collected in a variable can be read without further synchronization.
+// If a child process the command started keeps its output open, this
+// lasts until that child exits too; DrainOutput waits with a timeout.
+func (p *ProcessManager) WaitForOutputDrain() {
+	p.mu.Lock()
+	started := p.done != nil
+	p.mu.Unlock()
+
+	if !started {
+		return
+	}
+	for {
+		p.outMu.Lock()
+		if p.outEOF {
+			p.outMu.Unlock()
+			return
+		}
+		wake := p.outWakeLocked()
+		p.outMu.Unlock()
+		<-wake
+	}
+}
+
+// WithSoftCancel arranges for cancellation of ctx to shut the process down
+// gracefully: SIGTERM is sent first and the process is only killed if it has
+// not exited within DefaultGracePeriod.
+//
+// This differs from Stop and from the manager's internal context, which is
+// wired to exec.CommandContext and kills the process immediately. It returns
+// p so it can be chained after New.
+func (p *ProcessManager) WithSoftCancel(ctx context.Context) *ProcessManager {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.softCtx = ctx
+	if p.running {
+		go p.watchSoftCancel(ctx, p.done)
+	}
+	return p
+}
+
+// watchSoftCancel stops the process gracefully when ctx is cancelled before
+// the process exits.
+func (p *ProcessManager) watchSoftCancel(ctx context.Context, done <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+		p.StopGracefully(DefaultGracePeriod)
+	case <-done:
+	}
+}
+
+// StopGracefully asks the process to exit with SIGTERM, giving it up to
+// timeout to flush and save its state, and only then falls back to Stop,
+// which kills it. Either way the pipes or PTY are closed afterwards. It
+// reports whether the forced kill was needed. On Windows, which has no
+// SIGTERM, the process is killed straight away.
+func (p *ProcessManager) StopGracefully(timeout time.Duration) (forced bool, err error) {
+	p.mu.Lock()
+	proc, done, killGroup, paused := p.cmd.Process, p.done, p.killGroup, p.paused
+	running := p.running
+	p.mu.Unlock()
+
+	if proc == nil || done == nil || !running {
+		return false, p.Stop()
+	}
+
+	signal := proc.Signal
+	if killGroup {
+		signal = func(sig os.Signal) error { return signalGroup(proc, sig) }
+	}
+	// Signal fails on platforms without SIGTERM; go straight to the kill.
+	if signal(syscall.SIGTERM) == nil {
+		if paused {
+			// A suspended process only acts on SIGTERM once continued.
+			resumeProcess(proc, killGroup)
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-done:
+			return false, p.Stop()
+		case <-timer.C:
+		}
+	}
+	return true, p.Stop()
+}
+
+// TimeToFirstByte returns the time between starting the process and the
+// arrival of its first output on either stream. It returns 0 if the process
+// has not been started or has produced no output yet.
+func (p *ProcessManager) TimeToFirstByte() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.startTime.IsZero() || p.firstByteTime.IsZero() {
+		return 0
+	}
+	return p.firstByteTime.Sub(p.startTime)
+}
+
+// TotalDuration returns the time between starting the process and its exit.
+// It returns 0 while the process is still running or if it was never started.
+func (p *ProcessManager) TotalDuration() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.startTime.IsZero() || p.exitTime.IsZero() {
+		return 0
+	}
+	return p.exitTime.Sub(p.startTime)
+}
+
+// TotalRuntime returns the cumulative runtime of every run of the process,
+// including the current one if it is still running.
+func (p *ProcessManager) TotalRuntime() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := p.totalRuntime
+	if p.running {
+		total += time.Since(p.startTime)
+	}
+	return total
+}
+
+// BytesRead returns the number of bytes read from the process's stdout and
+// stderr, across restarts, since it was created or ResetBytesRead was last
+// called. A PTY merges both streams, so in PTY mode everything is counted as
+// stdout; split PTY mode counts them separately.
+func (p *ProcessManager) BytesRead() (stdout, stderr int64) {
+	return p.bytesRead[StdOut].Load(), p.bytesRead[StdErr].Load()
+}
+
+// ResetBytesRead sets both BytesRead counters back to zero, for example at
+// the start of each reporting interval in a long-running session.
+func (p *ProcessManager) ResetBytesRead() {
+	p.bytesRead[StdOut].Store(0)
+	p.bytesRead[StdErr].Store(0)
+}
+
+// Argv returns the argument vector the process was started with, including
+// the command name as argv[0]. It returns nil before the process is started.
+func (p *ProcessManager) Argv() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.argv...)
+}
+
+// Environ returns the environment the process was started with, as resolved
+// at start time. It returns nil before the process is started.
+func (p *ProcessManager) Environ() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.environ...)
+}
+
+// Cmd returns the underlying exec.Cmd, as an escape hatch for settings that
+// Config does not cover, such as SysProcAttr. Changes must be made before
+// the process is started; modifying it afterwards has undefined results.
+// Restart and relaunches run a copy that keeps the path, arguments,
+// environment, directory, extra files and SysProcAttr, the last as it was
+// before starting adjusted it for the mode, so the returned command only
+// describes the current run.
+func (p *ProcessManager) Cmd() *exec.Cmd {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cmd
+}
+
+// ExitCode returns the exit code of the process once it has exited, or -1
+// if it is still running, has not been started, or was terminated by a
+// signal. It remains available after Stop.
+func (p *ProcessManager) ExitCode() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == nil || p.running {
+		return -1
+	}
+	return p.state.ExitCode()
+}
+
+// Pid returns the process ID of the managed process, or -1 if not started.
+func (p *ProcessManager) Pid() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd.Process != nil {
 		return p.cmd.Process.Pid
 	}
 	return -1
@@ -287,22 +2201,93 @@ func (p *ProcessManager) Pid() int {
 
 // Session returns the underlying PTY file, if one is in use.
 // This allows for advanced terminal operations like setting window size.
+// On Windows it is the input side of the pseudo console.
 func (p *ProcessManager) Session() *os.File {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.pty
 }
 
+// ErrorSession returns the PTY file attached to stderr when the process was
+// started with StartWithSplitPTY, or nil otherwise.
+func (p *ProcessManager) ErrorSession() *os.File {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.errPTY
+}
+
+// HasPTY reports whether the process is attached to a PTY.
+func (p *ProcessManager) HasPTY() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pty != nil
+}
+
 // SetWindowSize sets the terminal window size for the PTY.
 // This is often required for complex interactive CLI tools to render correctly.
+//
+// The size is remembered and applied to every PTY started afterwards, so it
+// may be set before the process is started and survives Restart and
+// automatic relaunches.
+//
+// Once the process is running without a PTY (for example in pipes mode) it
+// changes nothing and returns ErrNoPTY, which callers that do not care about
+// the mode can ignore with errors.Is, or avoid by checking HasPTY first.
 func (p *ProcessManager) SetWindowSize(rows, cols uint16) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	ws := &pty.Winsize{
+		Rows: rows,
+		Cols: cols,
+	}
 	if p.pty == nil {
-		return fmt.Errorf("no PTY session active")
+		if p.done == nil {
+			p.winsize = ws
+			p.resizeScreenLocked()
+			p.recordResizeLocked()
+			return nil
+		}
+		return ErrNoPTY
 	}
 
-	return pty.Setsize(p.pty, &pty.Winsize{
-		Rows: rows,
-		Cols: cols,
-	})
+	if err := p.resizePTYLocked(ws); err != nil {
+		return err
+	}
+	p.winsize = ws
+	p.resizeScreenLocked()
+	p.recordResizeLocked()
+	return nil
+}
+
+// WindowSize returns the size last requested with SetWindowSize, or zeros if
+// none has been set.
+func (p *ProcessManager) WindowSize() (rows, cols uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.winsize == nil {
+		return 0, 0
+	}
+	return p.winsize.Rows, p.winsize.Cols
+}
+
+// initWindowSizeLocked gives a PTY about to be started the size of the
+// terminal on standard output if no size has been requested. The caller must
+// hold p.mu.
+func (p *ProcessManager) initWindowSizeLocked() {
+	if p.winsize != nil {
+		return
+	}
+	if p.winsize = terminalSize(); p.winsize != nil {
+		p.resizeScreenLocked()
+	}
+}
+
+// resizeScreenLocked matches the tracked screen to the requested window
+// size. The caller must hold p.mu.
+func (p *ProcessManager) resizeScreenLocked() {
+	if p.screen != nil && p.winsize != nil {
+		p.screen.Resize(int(p.winsize.Rows), int(p.winsize.Cols))
+	}
 }
@@ -0,0 +1,410 @@
+package pipe
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// requireCommand skips the test if name cannot be found in PATH.
+func requireCommand(t testing.TB, name string) {
+	t.Helper()
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s not available: %v", name, err)
+	}
+}
+
+func TestCloneAfterPTYStartsWithPipes(t *testing.T) {
+	requireCommand(t, "true")
+
+	pm := New("true")
+	if err := pm.StartWithPTY(); err != nil {
+		t.Fatalf("StartWithPTY: %v", err)
+	}
+	if err := pm.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	c := pm.Clone()
+	if err := c.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes on clone: %v", err)
+	}
+	if err := c.Wait(); err != nil {
+		t.Fatalf("Wait on clone: %v", err)
+	}
+}
+
+func TestStartMissingDir(t *testing.T) {
+	requireCommand(t, "true")
+	path, _ := exec.LookPath("true")
+	dir := filepath.Join(t.TempDir(), "missing")
+
+	for _, tc := range []struct {
+		name  string
+		start func(*ProcessManager) error
+	}{
+		{"pipes", (*ProcessManager).StartWithPipes},
+		{"PTY", (*ProcessManager).StartWithPTY},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pm := NewWithConfig(Config{Command: path, Dir: dir})
+			err := tc.start(pm)
+			if err == nil {
+				pm.Stop()
+				t.Fatal("start succeeded with a missing working directory")
+			}
+			if errors.Is(err, ErrCommandNotFound) {
+				t.Errorf("got %v, want an error other than ErrCommandNotFound", err)
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("got %v, want fs.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestStartMissingCommand(t *testing.T) {
+	pm := New(filepath.Join(t.TempDir(), "missing"))
+	if err := pm.StartWithPipes(); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("got %v, want ErrCommandNotFound", err)
+	}
+}
+
+func TestWriteAfterStop(t *testing.T) {
+	requireCommand(t, "cat")
+
+	for _, tc := range []struct {
+		name  string
+		start func(*ProcessManager) error
+	}{
+		{"pipes", (*ProcessManager).StartWithPipes},
+		{"PTY", (*ProcessManager).StartWithPTY},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pm := New("cat")
+			if err := tc.start(pm); err != nil {
+				t.Fatalf("start: %v", err)
+			}
+			pm.Stop()
+
+			if _, err := pm.Write([]byte("x")); !errors.Is(err, ErrNoInputPipe) {
+				t.Errorf("Write after Stop: got %v, want ErrNoInputPipe", err)
+			}
+			if err := pm.Writeln("x"); !errors.Is(err, ErrNoInputPipe) {
+				t.Errorf("Writeln after Stop: got %v, want ErrNoInputPipe", err)
+			}
+		})
+	}
+}
+
+// panicScript prints a line that the test handlers panic on, then, in a
+// separate chunk, a line that should still be delivered.
+const panicScript = "printf 'boom\\n'; sleep 0.1; printf 'after\\n'"
+
+func TestHandlerPanicReportedToStderrHandler(t *testing.T) {
+	requireCommand(t, "sh")
+
+	var mu sync.Mutex
+	var stdout, stderr bytes.Buffer
+	pm := NewWithConfig(Config{
+		Command: "sh",
+		Args:    []string{"-c", panicScript},
+		OnOutput: func(data []byte) {
+			if bytes.Contains(data, []byte("boom")) {
+				panic("handler failed")
+			}
+			mu.Lock()
+			stdout.Write(data)
+			mu.Unlock()
+		},
+		OnError: func(data []byte) {
+			mu.Lock()
+			stderr.Write(data)
+			mu.Unlock()
+		},
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	if err := pm.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	pm.WaitForOutputDrain()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := stdout.String(); got != "after\n" {
+		t.Errorf("output after the panic = %q, want %q", got, "after\n")
+	}
+	if want := "[Handler Panic]: handler failed\n"; stderr.String() != want {
+		t.Errorf("stderr handler got %q, want %q", stderr.String(), want)
+	}
+}
+
+func TestOnHandlerPanic(t *testing.T) {
+	requireCommand(t, "sh")
+
+	var mu sync.Mutex
+	var stdout bytes.Buffer
+	var recovered []any
+	var chunks []string
+	pm := NewWithConfig(Config{
+		Command: "sh",
+		Args:    []string{"-c", panicScript},
+		OnOutput: func(data []byte) {
+			if bytes.Contains(data, []byte("boom")) {
+				panic("handler failed")
+			}
+			mu.Lock()
+			stdout.Write(data)
+			mu.Unlock()
+		},
+		OnHandlerPanic: func(r any, data []byte) {
+			mu.Lock()
+			recovered = append(recovered, r)
+			chunks = append(chunks, string(data))
+			mu.Unlock()
+		},
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	if err := pm.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	pm.WaitForOutputDrain()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := stdout.String(); got != "after\n" {
+		t.Errorf("output after the panic = %q, want %q", got, "after\n")
+	}
+	if len(recovered) != 1 || recovered[0] != "handler failed" {
+		t.Errorf("OnHandlerPanic got values %v, want [handler failed]", recovered)
+	}
+	if len(chunks) != 1 || chunks[0] != "boom\n" {
+		t.Errorf("OnHandlerPanic got chunks %q, want [\"boom\\n\"]", chunks)
+	}
+}
+
+func TestRestartOnCodes(t *testing.T) {
+	requireCommand(t, "sh")
+
+	// Exits 75 on the first run and 0 on the second.
+	marker := filepath.Join(t.TempDir(), "ran")
+	script := `echo run; if [ -e "$1" ]; then exit 0; fi; : > "$1"; exit 75`
+
+	var mu sync.Mutex
+	var out bytes.Buffer
+	pm := NewWithConfig(Config{
+		Command:        "sh",
+		Args:           []string{"-c", script, "sh", marker},
+		RestartOnCodes: []int{75},
+		RestartDelay:   200 * time.Millisecond,
+		OnOutput: func(data []byte) {
+			mu.Lock()
+			out.Write(data)
+			mu.Unlock()
+		},
+	})
+	start := time.Now()
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	if err := pm.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	pm.WaitForOutputDrain()
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("relaunched after %v, want RestartDelay to be waited out", elapsed)
+	}
+	if code := pm.ExitCode(); code != 0 {
+		t.Errorf("ExitCode = %d, want 0", code)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := out.String(); got != "run\nrun\n" {
+		t.Errorf("output = %q, want two runs", got)
+	}
+}
+
+func TestControlKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		key, want string
+	}{
+		{"KeyEnter", KeyEnter, "\r"},
+		{"KeyArrowUp", KeyArrowUp, "\x1b[A"},
+		{"KeyArrowDown", KeyArrowDown, "\x1b[B"},
+		{"KeyArrowRight", KeyArrowRight, "\x1b[C"},
+		{"KeyArrowLeft", KeyArrowLeft, "\x1b[D"},
+		{"KeyTab", KeyTab, "\t"},
+		{"KeyEscape", KeyEscape, "\x1b"},
+		{"KeyBackspace", KeyBackspace, "\x7f"},
+		{"KeyInsert", KeyInsert, "\x1b[2~"},
+		{"KeyDelete", KeyDelete, "\x1b[3~"},
+		{"KeyHome", KeyHome, "\x1b[H"},
+		{"KeyEnd", KeyEnd, "\x1b[F"},
+		{"KeyPageUp", KeyPageUp, "\x1b[5~"},
+		{"KeyPageDown", KeyPageDown, "\x1b[6~"},
+		{"KeyCtrlA", KeyCtrlA, "\x01"},
+		{"KeyCtrlC", KeyCtrlC, "\x03"},
+		{"KeyCtrlD", KeyCtrlD, "\x04"},
+		{"KeyCtrlE", KeyCtrlE, "\x05"},
+		{"KeyCtrlL", KeyCtrlL, "\x0c"},
+		{"KeyCtrlR", KeyCtrlR, "\x12"},
+		{"KeyCtrlU", KeyCtrlU, "\x15"},
+		{"KeyCtrlW", KeyCtrlW, "\x17"},
+		{"KeyCtrlZ", KeyCtrlZ, "\x1a"},
+	} {
+		if tc.key != tc.want {
+			t.Errorf("%s = %q, want %q", tc.name, tc.key, tc.want)
+		}
+	}
+}
+
+// TestFunctionKeys checks the function keys against the sequences xterm
+// sends for them, as listed by infocmp xterm-256color.
+func TestFunctionKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		key, want string
+	}{
+		{"KeyF1", KeyF1, "\x1bOP"},
+		{"KeyF2", KeyF2, "\x1bOQ"},
+		{"KeyF3", KeyF3, "\x1bOR"},
+		{"KeyF4", KeyF4, "\x1bOS"},
+		{"KeyF5", KeyF5, "\x1b[15~"},
+		{"KeyF6", KeyF6, "\x1b[17~"},
+		{"KeyF7", KeyF7, "\x1b[18~"},
+		{"KeyF8", KeyF8, "\x1b[19~"},
+		{"KeyF9", KeyF9, "\x1b[20~"},
+		{"KeyF10", KeyF10, "\x1b[21~"},
+		{"KeyF11", KeyF11, "\x1b[23~"},
+		{"KeyF12", KeyF12, "\x1b[24~"},
+	} {
+		if tc.key != tc.want {
+			t.Errorf("%s = %q, want %q", tc.name, tc.key, tc.want)
+		}
+	}
+}
+
+func TestRestartOnCodesRelaunchFails(t *testing.T) {
+	requireCommand(t, "sh")
+
+	errHook := errors.New("hook failed")
+	var starts int
+	pm := NewWithConfig(Config{
+		Command:        "sh",
+		Args:           []string{"-c", "exit 75"},
+		RestartOnCodes: []int{75},
+		RestartDelay:   time.Millisecond,
+		BeforeStart: func(*exec.Cmd) error {
+			if starts++; starts > 1 {
+				return errHook
+			}
+			return nil
+		},
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	err := pm.Wait()
+	if !errors.Is(err, errHook) {
+		t.Errorf("Wait = %v, want the relaunch error", err)
+	}
+	var perr *ProcessError
+	if !errors.As(err, &perr) || perr.ExitCode != 75 {
+		t.Errorf("Wait = %v, want the exit status 75 as well", err)
+	}
+	if code := pm.ExitCode(); code != 75 {
+		t.Errorf("ExitCode = %d, want 75", code)
+	}
+}
+
+func TestStopDuringRestartDelay(t *testing.T) {
+	requireCommand(t, "sh")
+
+	pm := NewWithConfig(Config{
+		Command:        "sh",
+		Args:           []string{"-c", "exit 75"},
+		RestartOnCodes: []int{75},
+		RestartDelay:   time.Minute,
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	pm.Stop()
+
+	select {
+	case <-pm.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not end the session while waiting to relaunch")
+	}
+}
+
+func TestSetWindowSizePipes(t *testing.T) {
+	requireCommand(t, "cat")
+
+	pm := New("cat")
+	if err := pm.SetWindowSize(30, 100); err != nil {
+		t.Fatalf("SetWindowSize before start: %v", err)
+	}
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	defer pm.Stop()
+
+	if err := pm.SetWindowSize(50, 120); !errors.Is(err, ErrNoPTY) {
+		t.Errorf("SetWindowSize in pipes mode = %v, want ErrNoPTY", err)
+	}
+	if rows, cols := pm.WindowSize(); rows != 30 || cols != 100 {
+		t.Errorf("WindowSize = %dx%d, want the size set before the start, 30x100", rows, cols)
+	}
+}
+
+func TestExtraFilesPipesOnly(t *testing.T) {
+	requireCommand(t, "cat")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	pm := NewWithConfig(Config{Command: "cat", ExtraFiles: []*os.File{w}})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	defer pm.Stop()
+	if err := pm.Restart(); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	if got := len(pm.Cmd().ExtraFiles); got != 1 {
+		t.Errorf("after Restart in pipes mode the command has %d extra files, want 1", got)
+	}
+
+	c := pm.Clone()
+	if err := c.StartWithPTY(); err != nil {
+		t.Fatalf("StartWithPTY on clone: %v", err)
+	}
+	defer c.Stop()
+	if got := len(c.Cmd().ExtraFiles); got != 0 {
+		t.Errorf("clone started with a PTY has %d extra files, want 0", got)
+	}
+	if err := c.Restart(); err != nil {
+		t.Fatalf("Restart on clone: %v", err)
+	}
+	if got := len(c.Cmd().ExtraFiles); got != 0 {
+		t.Errorf("clone restarted with a PTY has %d extra files, want 0", got)
+	}
+}
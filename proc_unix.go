@@ -0,0 +1,94 @@
+//go:build !windows
+
+package pipe
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// suspendProcess stops proc, or its whole process group, with SIGSTOP.
+func suspendProcess(proc *os.Process, group bool) error {
+	if group {
+		return signalGroup(proc, syscall.SIGSTOP)
+	}
+	return proc.Signal(syscall.SIGSTOP)
+}
+
+// resumeProcess continues a stopped proc, or its whole process group, with
+// SIGCONT.
+func resumeProcess(proc *os.Process, group bool) error {
+	if group {
+		return signalGroup(proc, syscall.SIGCONT)
+	}
+	return proc.Signal(syscall.SIGCONT)
+}
+
+// setProcessGroup makes cmd start in a process group of its own, so that
+// signalGroup also reaches the processes it spawns. Processes started in a
+// new session, as PTY sessions are, already lead their own group.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if !cmd.SysProcAttr.Setsid {
+		cmd.SysProcAttr.Setpgid = true
+	}
+}
+
+// signalGroup sends sig to every process in the group led by proc.
+func signalGroup(proc *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return proc.Signal(sig)
+	}
+	return syscall.Kill(-proc.Pid, s)
+}
+
+// clearProcessGroup undoes setProcessGroup before cmd is started in a new
+// session, which fails if Setpgid is also set. This happens when Start
+// falls back from pipes mode to a PTY.
+func clearProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr != nil {
+		cmd.SysProcAttr.Setpgid = false
+	}
+}
+
+// setCredential makes cmd run as cred, if it is set.
+func setCredential(cmd *exec.Cmd, cred *Credential) error {
+	if cred == nil {
+		return nil
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:         cred.Uid,
+		Gid:         cred.Gid,
+		Groups:      cred.Groups,
+		NoSetGroups: cred.NoSetGroups,
+	}
+	return nil
+}
+
+// posixShells are the shells whose -c takes the positional parameters after
+// the command line, as shellCommand relies on.
+var posixShells = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true,
+	"mksh": true, "ash": true, "yash": true,
+}
+
+// shellCommand returns a command running cmdline with $SHELL -c, or
+// /bin/sh if SHELL is unset or names a shell, such as fish or csh, that
+// does not follow POSIX. args become $1, $2 and so on, with the shell
+// itself as $0.
+func shellCommand(ctx context.Context, cmdline string, args []string) *exec.Cmd {
+	shell := os.Getenv("SHELL")
+	if !posixShells[filepath.Base(shell)] {
+		shell = "/bin/sh"
+	}
+	return exec.CommandContext(ctx, shell, append([]string{"-c", cmdline, shell}, args...)...)
+}
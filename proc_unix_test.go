@@ -0,0 +1,101 @@
+//go:build !windows
+
+package pipe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShellCommand(t *testing.T) {
+	for _, tc := range []struct {
+		shell, want string
+	}{
+		{"", "/bin/sh"},
+		{"/bin/bash", "/bin/bash"},
+		{"/usr/bin/zsh", "/usr/bin/zsh"},
+		{"/usr/bin/fish", "/bin/sh"},
+		{"/bin/csh", "/bin/sh"},
+		{"/bin/tcsh", "/bin/sh"},
+	} {
+		t.Run(tc.shell, func(t *testing.T) {
+			t.Setenv("SHELL", tc.shell)
+			cmd := shellCommand(context.Background(), `echo "$1"`, []string{"a"})
+			want := []string{tc.want, "-c", `echo "$1"`, tc.want, "a"}
+			if len(cmd.Args) != len(want) {
+				t.Fatalf("Args = %q, want %q", cmd.Args, want)
+			}
+			for i := range want {
+				if cmd.Args[i] != want[i] {
+					t.Fatalf("Args = %q, want %q", cmd.Args, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStopAfterWaitLeavesGroupAlone(t *testing.T) {
+	requireCommand(t, "sh")
+	requireCommand(t, "sleep")
+
+	// The leader exits straight away, leaving a child in its group that
+	// creates marker a little later.
+	marker := filepath.Join(t.TempDir(), "survived")
+	pm := NewWithConfig(Config{
+		Command: "sh",
+		Args:    []string{"-c", `(sleep 0.3; : > "$1") >/dev/null 2>&1 &`, "sh", marker},
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	if err := pm.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if err := pm.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(marker); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("child left in the group was killed by Stop after Wait")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestForwardSignalsReachesGroup(t *testing.T) {
+	requireCommand(t, "sh")
+	requireCommand(t, "sleep")
+
+	// Only the backgrounded subshell, not the leader, reports the signal.
+	script := `trap 'echo leader' USR1
+( trap 'echo child; exit 0' USR1; echo ready; while :; do sleep 0.05; done ) &
+wait; wait`
+	pm := NewWithConfig(Config{Command: "sh", Args: []string{"-c", script}})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	defer pm.Stop()
+
+	stop, err := pm.ForwardSignals(syscall.SIGUSR1)
+	if err != nil {
+		t.Fatalf("ForwardSignals: %v", err)
+	}
+	defer stop()
+
+	if _, err := pm.Expect("ready\n", 5*time.Second); err != nil {
+		t.Fatalf("Expect ready: %v", err)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	if _, err := pm.Expect("child\n", 5*time.Second); err != nil {
+		t.Fatalf("forwarded signal did not reach the child: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build windows
+
+package pipe
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var errSuspendUnsupported = errors.New("suspending a process is not supported on windows")
+
+var errCredentialUnsupported = errors.New("running a process as another user is not supported on windows")
+
+// suspendProcess is not supported on Windows.
+func suspendProcess(proc *os.Process, group bool) error {
+	return errSuspendUnsupported
+}
+
+// resumeProcess is not supported on Windows.
+func resumeProcess(proc *os.Process, group bool) error {
+	return errSuspendUnsupported
+}
+
+// setProcessGroup does nothing on Windows.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup signals only proc itself on Windows, which has no process
+// groups in the Unix sense.
+func signalGroup(proc *os.Process, sig os.Signal) error {
+	return proc.Signal(sig)
+}
+
+// clearProcessGroup does nothing on Windows.
+func clearProcessGroup(cmd *exec.Cmd) {}
+
+// setCredential is not supported on Windows.
+func setCredential(cmd *exec.Cmd, cred *Credential) error {
+	if cred != nil {
+		return errCredentialUnsupported
+	}
+	return nil
+}
+
+// shellCommand returns a command running cmdline with %ComSpec% /C, or
+// cmd.exe if ComSpec is unset. The command line is given to the shell
+// verbatim, as cmd.exe does not follow the quoting rules exec.Cmd applies
+// to arguments; args are quoted and appended to it.
+func shellCommand(ctx context.Context, cmdline string, args []string) *exec.Cmd {
+	shell := os.Getenv("ComSpec")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	line := syscall.EscapeArg(shell) + " /C " + cmdline
+	for _, arg := range args {
+		line += " " + syscall.EscapeArg(arg)
+	}
+	cmd := exec.CommandContext(ctx, shell)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: line}
+	return cmd
+}
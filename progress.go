@@ -0,0 +1,163 @@
+package pipe
+
+import (
+	"io"
+	"sync"
+)
+
+// CollapseProgress returns a copy of data in which carriage-return progress
+// updates have been collapsed, keeping only the final state of each line.
+//
+// Tools such as curl or docker pull redraw a progress bar by emitting a bare
+// "\r" followed by the new state. A bare "\r" discards everything written to
+// the current line so far, while "\r\n" (as produced by a PTY) and "\n" are
+// treated as regular line endings and preserved, as is a run of "\r" before
+// a "\n", which a PTY makes of a program's own "\r\n".
+func CollapseProgress(data []byte) []byte {
+	var out []byte
+	c := &ProgressCollapser{w: writerFunc(func(b []byte) (int, error) {
+		out = append(out, b...)
+		return len(b), nil
+	})}
+	c.Write(data)
+	c.Flush()
+	return out
+}
+
+// ProgressCollapser is an io.Writer that collapses carriage-return progress
+// updates before forwarding complete lines to an underlying writer.
+//
+// It is intended for the captured copy of the output (a log file or buffer);
+// handlers installed on the ProcessManager still see the raw stream live.
+// Config.CollapseProgress applies it to CaptureTo, the output writers and
+// LogFile. For other destinations, wrap the writer inside an OutputHandler:
+//
+//	logc := pipe.NewProgressCollapser(logFile)
+//	pm.SetOutputHandler(func(data []byte) {
+//		os.Stdout.Write(data)
+//		logc.Write(data)
+//	})
+//
+// Call Flush once the process has exited to emit a trailing partial line.
+type ProgressCollapser struct {
+	w       io.Writer
+	mu      sync.Mutex
+	line    []byte
+	pending bool // a '\r' was seen at the end of the previous write
+}
+
+// NewProgressCollapser returns a ProgressCollapser that writes to w.
+func NewProgressCollapser(w io.Writer) *ProgressCollapser {
+	return &ProgressCollapser{w: w}
+}
+
+// Write consumes data, forwarding each completed line to the underlying
+// writer. It always reports len(data) bytes written unless the underlying
+// writer fails.
+func (c *ProgressCollapser) Write(data []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, b := range data {
+		if c.pending {
+			if b == '\r' {
+				// A run of carriage returns is decided by what follows
+				// it: a PTY turns "\r\n" into "\r\r\n".
+				continue
+			}
+			c.pending = false
+			if b == '\n' {
+				if err := c.emit("\r\n"); err != nil {
+					return 0, err
+				}
+				continue
+			}
+			// A bare carriage return starts a new progress state.
+			c.line = c.line[:0]
+		}
+
+		switch b {
+		case '\r':
+			c.pending = true
+		case '\n':
+			if err := c.emit("\n"); err != nil {
+				return 0, err
+			}
+		default:
+			c.line = append(c.line, b)
+		}
+	}
+	return len(data), nil
+}
+
+// Flush writes any buffered partial line to the underlying writer.
+// A trailing carriage return is treated as the end of a progress update.
+func (c *ProgressCollapser) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending = false
+	if len(c.line) == 0 {
+		return nil
+	}
+	return c.emit("")
+}
+
+// emit writes the current line followed by the given terminator.
+func (c *ProgressCollapser) emit(term string) error {
+	c.line = append(c.line, term...)
+	_, err := c.w.Write(c.line)
+	c.line = c.line[:0]
+	return err
+}
+
+// initCollapsers puts a ProgressCollapser in front of the captured copies of
+// the output if Config.CollapseProgress is set.
+func (p *ProcessManager) initCollapsers() {
+	if !p.collapseProgress {
+		return
+	}
+	for s := range p.collapsers {
+		s := Stream(s)
+		// Called by capture with p.captureMu held.
+		p.collapsers[s] = NewProgressCollapser(writerFunc(func(b []byte) (int, error) {
+			if err := p.captureLocked(s, b); err != nil {
+				p.collapseErrs = append(p.collapseErrs, err)
+			}
+			return len(b), nil
+		}))
+	}
+	if p.logFile != nil {
+		p.logCollapser = NewProgressCollapser(writerFunc(func(b []byte) (int, error) {
+			p.appendLog(b)
+			return len(b), nil
+		}))
+	}
+}
+
+// flushCollapsers writes out the lines still incomplete in the collapsers,
+// once the output has ended.
+func (p *ProcessManager) flushCollapsers() {
+	if !p.collapseProgress {
+		return
+	}
+	p.captureMu.Lock()
+	for _, c := range p.collapsers {
+		c.Flush()
+	}
+	errs := p.collapseErrs
+	p.collapseErrs = nil
+	p.captureMu.Unlock()
+	p.writerErrors(errs)
+
+	if p.logCollapser != nil {
+		p.logCollapser.Flush()
+	}
+}
+
+// writerFunc adapts a function to the io.Writer interface.
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
@@ -0,0 +1,77 @@
+package pipe
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollapseProgress(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"bare CR", "10%\r50%\r100%\ndone\n", "100%\ndone\n"},
+		{"CRLF", "10%\r100%\r\ndone\r\n", "100%\r\ndone\r\n"},
+		{"PTY CRCRLF", "10%\r50%\r100%\r\r\ndone\n", "100%\r\ndone\n"},
+		{"trailing CR", "10%\r100%\r", "100%"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(CollapseProgress([]byte(tc.in))); got != tc.want {
+				t.Errorf("CollapseProgress(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProgressCollapserSplitWrites(t *testing.T) {
+	var out []byte
+	c := NewProgressCollapser(writerFunc(func(b []byte) (int, error) {
+		out = append(out, b...)
+		return len(b), nil
+	}))
+	for _, chunk := range []string{"50%\r", "100%\r", "\r", "\ndone\n"} {
+		c.Write([]byte(chunk))
+	}
+	c.Flush()
+	if want := "100%\r\ndone\n"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestCollapseProgressOption(t *testing.T) {
+	requireCommand(t, "sh")
+
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	var captured, written bytes.Buffer
+	pm := NewWithConfig(Config{
+		Command:          "sh",
+		Args:             []string{"-c", `printf '10%%\r50%%\r100%%\ndone\n1/2\r2/2'`},
+		CollapseProgress: true,
+		OutputWriter:     &written,
+		LogFile:          logPath,
+	})
+	pm.CaptureTo(&captured)
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	if err := pm.Wait(); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	pm.WaitForOutputDrain()
+
+	const want = "100%\ndone\n2/2"
+	if got := captured.String(); got != want {
+		t.Errorf("CaptureTo got %q, want %q", got, want)
+	}
+	if got := written.String(); got != want {
+		t.Errorf("OutputWriter got %q, want %q", got, want)
+	}
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(logged) != want {
+		t.Errorf("LogFile got %q, want %q", logged, want)
+	}
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+
+package pipe
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// StartWithSplitPTY starts the process with two pseudo-terminals: one
+// attached to stdin and stdout, and a separate one attached to stderr.
+// Output from the stderr PTY is delivered to the error handler, so the two
+// streams stay distinguishable while both still appear to be terminals.
+//
+// This serves programs that check the TTY-ness of each fd independently.
+// Caveats: it is only available on Unix; the stdin/stdout PTY is the
+// controlling terminal, so job-control keys like KeyCtrlC only act through
+// it; and ordering between the two streams is not preserved. Most programs
+// should use StartWithPTY.
+func (p *ProcessManager) StartWithSplitPTY() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkStartLocked(); err != nil {
+		return err
+	}
+	p.saveAttrLocked()
+	return p.startSplitPTYLocked()
+}
+
+// startSplitPTYLocked starts the process in split PTY mode. The caller must
+// hold p.mu.
+func (p *ProcessManager) startSplitPTYLocked() error {
+	if err := p.runBeforeStartLocked(); err != nil {
+		return err
+	}
+	ptm, pts, err := pty.Open()
+	if err != nil {
+		return fmt.Errorf("open PTY: %w", err)
+	}
+	errPtm, errPts, err := pty.Open()
+	if err != nil {
+		ptm.Close()
+		pts.Close()
+		return fmt.Errorf("open stderr PTY: %w", err)
+	}
+	p.initWindowSizeLocked()
+	if p.winsize != nil {
+		pty.Setsize(ptm, p.winsize)
+		pty.Setsize(errPtm, p.winsize)
+	}
+
+	p.cmd.Stdin = pts
+	p.cmd.Stdout = pts
+	p.cmd.Stderr = errPts
+	if p.cmd.SysProcAttr == nil {
+		p.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	p.cmd.SysProcAttr.Setsid = true
+	p.cmd.SysProcAttr.Setctty = true
+	p.cmd.SysProcAttr.Setpgid = false
+	p.prepareCmdLocked()
+
+	err = p.cmd.Start()
+	pts.Close()
+	errPts.Close()
+	if err != nil {
+		ptm.Close()
+		errPtm.Close()
+		return p.startError("start PTY failed", err)
+	}
+	p.pty = ptm
+	p.errPTY = errPtm
+	p.mode = modeSplitPTY
+	p.started(2)
+
+	go p.readOutput(ptm)
+	go p.readFromReader(errPtm, StdErr)
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package pipe
+
+import "errors"
+
+var errSplitPTYUnsupported = errors.New("split PTY mode is not supported on windows")
+
+// StartWithSplitPTY is not supported on Windows.
+func (p *ProcessManager) StartWithSplitPTY() error {
+	return errSplitPTYUnsupported
+}
+
+func (p *ProcessManager) startSplitPTYLocked() error {
+	return errSplitPTYUnsupported
+}
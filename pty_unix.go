@@ -0,0 +1,41 @@
+//go:build !windows
+
+package pipe
+
+import "github.com/creack/pty"
+
+// ptyEOF is the terminal's end-of-file character, typed by CloseStdin.
+const ptyEOF = KeyCtrlD
+
+// conPTY is only used on Windows.
+type conPTY struct{}
+
+// startPTYLocked starts the process in PTY mode. The caller must hold p.mu.
+func (p *ProcessManager) startPTYLocked() error {
+	if err := p.runBeforeStartLocked(); err != nil {
+		return err
+	}
+	clearProcessGroup(p.cmd)
+	p.prepareCmdLocked()
+	p.initWindowSizeLocked()
+	f, err := pty.StartWithSize(p.cmd, p.winsize)
+	if err != nil {
+		return p.startError("start PTY failed", err)
+	}
+	p.pty = f
+	p.mode = modePTY
+	p.started(1)
+
+	go p.readOutput(f)
+	return nil
+}
+
+// resizePTYLocked applies ws to the PTYs in use. The caller must hold p.mu.
+func (p *ProcessManager) resizePTYLocked(ws *pty.Winsize) error {
+	if p.errPTY != nil {
+		if err := pty.Setsize(p.errPTY, ws); err != nil {
+			return err
+		}
+	}
+	return pty.Setsize(p.pty, ws)
+}
@@ -0,0 +1,212 @@
+//go:build windows
+
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"unsafe"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/windows"
+)
+
+// UpdateProcThreadAttribute is called directly because the pseudo console
+// attribute takes the handle itself as its value, which the wrapper in
+// golang.org/x/sys/windows can only accept as an unsafe.Pointer.
+var procUpdateProcThreadAttribute = windows.NewLazySystemDLL("kernel32.dll").NewProc("UpdateProcThreadAttribute")
+
+// ptyEOF ends console input the way a user would, for CloseStdin.
+const ptyEOF = KeyCtrlZ + KeyEnter
+
+// conPTY is a Windows pseudo console. It is closed once the process exits,
+// which ends its output stream.
+type conPTY struct {
+	mu     sync.Mutex
+	handle windows.Handle
+	closed bool
+}
+
+// startPTYLocked starts the process attached to a new pseudo console. The
+// caller must hold p.mu.
+func (p *ProcessManager) startPTYLocked() error {
+	if p.cmd.Err != nil {
+		return p.startError("start PTY failed", p.cmd.Err)
+	}
+	if err := p.runBeforeStartLocked(); err != nil {
+		return err
+	}
+
+	var inR, inW, outR, outW windows.Handle
+	if err := windows.CreatePipe(&inR, &inW, nil, 0); err != nil {
+		return fmt.Errorf("create console input pipe: %w", err)
+	}
+	if err := windows.CreatePipe(&outR, &outW, nil, 0); err != nil {
+		windows.CloseHandle(inR)
+		windows.CloseHandle(inW)
+		return fmt.Errorf("create console output pipe: %w", err)
+	}
+
+	p.initWindowSizeLocked()
+	size := windows.Coord{X: defaultScreenCols, Y: defaultScreenRows}
+	if p.winsize != nil {
+		size = windows.Coord{X: int16(p.winsize.Cols), Y: int16(p.winsize.Rows)}
+	}
+	var handle windows.Handle
+	err := windows.CreatePseudoConsole(size, inR, outW, 0, &handle)
+	// The console keeps its own copies of its ends of the pipes.
+	windows.CloseHandle(inR)
+	windows.CloseHandle(outW)
+	if err != nil {
+		windows.CloseHandle(inW)
+		windows.CloseHandle(outR)
+		return fmt.Errorf("create pseudo console: %w", err)
+	}
+	con := &conPTY{handle: handle}
+
+	pi, err := p.createConsoleProcess(handle)
+	if err == nil {
+		p.cmd.Process, err = os.FindProcess(int(pi.ProcessId))
+		windows.CloseHandle(pi.Thread)
+		if err != nil {
+			windows.TerminateProcess(pi.Process, 1)
+			windows.CloseHandle(pi.Process)
+		}
+	}
+	if err != nil {
+		con.close()
+		windows.CloseHandle(inW)
+		windows.CloseHandle(outR)
+		return p.startError("start PTY failed", err)
+	}
+
+	p.prepareCmdLocked()
+	p.pty = os.NewFile(uintptr(inW), "conpty-input")
+	p.conpty = con
+	p.mode = modePTY
+	p.started(1)
+
+	go con.watch(p.ctx, pi.Process, p.cmd.Cancel)
+	go p.readOutput(os.NewFile(uintptr(outR), "conpty-output"))
+	return nil
+}
+
+// createConsoleProcess starts p.cmd attached to the pseudo console. The
+// caller must hold p.mu.
+func (p *ProcessManager) createConsoleProcess(console windows.Handle) (*windows.ProcessInformation, error) {
+	attrs, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return nil, err
+	}
+	defer attrs.Delete()
+
+	r1, _, e1 := procUpdateProcThreadAttribute.Call(
+		uintptr(unsafe.Pointer(attrs.List())), 0,
+		windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE,
+		uintptr(console), unsafe.Sizeof(console), 0, 0)
+	if r1 == 0 {
+		return nil, fmt.Errorf("attach pseudo console: %w", e1)
+	}
+
+	si := &windows.StartupInfoEx{ProcThreadAttributeList: attrs.List()}
+	si.Cb = uint32(unsafe.Sizeof(*si))
+	si.Flags = windows.STARTF_USESTDHANDLES
+
+	cmdLine := windows.ComposeCommandLine(p.cmd.Args)
+	if p.cmd.SysProcAttr != nil && p.cmd.SysProcAttr.CmdLine != "" {
+		cmdLine = p.cmd.SysProcAttr.CmdLine
+	}
+	app, err := windows.UTF16PtrFromString(p.cmd.Path)
+	if err != nil {
+		return nil, err
+	}
+	line, err := windows.UTF16PtrFromString(cmdLine)
+	if err != nil {
+		return nil, err
+	}
+	var dir *uint16
+	if p.cmd.Dir != "" {
+		if dir, err = windows.UTF16PtrFromString(p.cmd.Dir); err != nil {
+			return nil, err
+		}
+	}
+	env, err := envBlock(p.cmd.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	pi := new(windows.ProcessInformation)
+	flags := uint32(windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT)
+	err = windows.CreateProcess(app, line, nil, nil, false, flags, env, dir, &si.StartupInfo, pi)
+	if err != nil {
+		// Report failures the way os.StartProcess does.
+		return nil, &fs.PathError{Op: "fork/exec", Path: p.cmd.Path, Err: err}
+	}
+	return pi, nil
+}
+
+// envBlock encodes env as a Windows environment block.
+func envBlock(env []string) (*uint16, error) {
+	var block []uint16
+	for _, kv := range env {
+		s, err := windows.UTF16FromString(kv)
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, s...)
+	}
+	block = append(block, 0)
+	if len(env) == 0 {
+		block = append(block, 0)
+	}
+	return &block[0], nil
+}
+
+// watch waits for the process behind proc to exit and then closes the
+// console, which ends the output stream. If ctx is done first, the process
+// is killed with cancel, as exec.CommandContext would.
+func (c *conPTY) watch(ctx context.Context, proc windows.Handle, cancel func() error) {
+	defer windows.CloseHandle(proc)
+
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-exited:
+		}
+	}()
+
+	windows.WaitForSingleObject(proc, windows.INFINITE)
+	close(exited)
+	c.close()
+}
+
+func (c *conPTY) resize(ws *pty.Winsize) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	return windows.ResizePseudoConsole(c.handle, windows.Coord{X: int16(ws.Cols), Y: int16(ws.Rows)})
+}
+
+func (c *conPTY) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		windows.ClosePseudoConsole(c.handle)
+		c.closed = true
+	}
+}
+
+// resizePTYLocked applies ws to the pseudo console. The caller must hold
+// p.mu.
+func (p *ProcessManager) resizePTYLocked(ws *pty.Winsize) error {
+	return p.conpty.resize(ws)
+}
@@ -0,0 +1,62 @@
+package pipe
+
+import (
+	"strings"
+	"time"
+)
+
+// Query treats the process as a line-oriented request/response server: it
+// writes request with Writeln, so followed by Config.LineEnding, and returns
+// the next line of output, without its line ending. Concurrent calls are
+// serialized so responses are never interleaved.
+//
+// If Config.QueryTerminator is set, the response may span several lines and
+// is read up to (but not including) a line equal to the terminator; the lines
+// are returned joined with "\n".
+//
+// Any output that was not consumed before the request is discarded, so a
+// response that arrives after an earlier Query timed out cannot be mistaken
+// for the answer to the next one. Query reads from the same buffer as Expect,
+// which in pipes mode also receives stderr, and is best suited to pipes mode
+// since a PTY echoes the request back as the first line.
+func (p *ProcessManager) Query(request string, timeout time.Duration) (string, error) {
+	p.queryMu.Lock()
+	defer p.queryMu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+
+	p.outMu.Lock()
+	p.outBuf = p.outBuf[:0]
+	p.outMu.Unlock()
+
+	if err := p.Writeln(request); err != nil {
+		return "", err
+	}
+
+	if p.queryTerminator == "" {
+		return p.expectLine(time.Until(deadline))
+	}
+
+	var lines []string
+	for {
+		line, err := p.expectLine(time.Until(deadline))
+		if err != nil {
+			if line != "" {
+				lines = append(lines, line)
+			}
+			return strings.Join(lines, "\n"), err
+		}
+		if line == p.queryTerminator {
+			return strings.Join(lines, "\n"), nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// expectLine waits for the next complete line of output and returns it with
+// the trailing "\n" or "\r\n" removed.
+func (p *ProcessManager) expectLine(timeout time.Duration) (string, error) {
+	out, err := p.Expect("\n", timeout)
+	line := strings.TrimSuffix(string(out), "\n")
+	return strings.TrimSuffix(line, "\r"), err
+}
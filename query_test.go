@@ -0,0 +1,102 @@
+package pipe
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// queryServer answers each request line with "got <line>", after printing a
+// stale line that no request asked for. "multi" is answered with two lines
+// and "end", and "slow" is not answered at all.
+const queryServer = `echo stale
+while read -r line; do
+	case $line in
+	multi) printf 'one\ntwo\nend\n' ;;
+	slow) ;;
+	*) echo "got $line" ;;
+	esac
+done`
+
+func startQueryServer(t *testing.T, terminator string) *ProcessManager {
+	t.Helper()
+	requireCommand(t, "sh")
+
+	pm := NewWithConfig(Config{
+		Command:         "sh",
+		Args:            []string{"-c", queryServer},
+		QueryTerminator: terminator,
+	})
+	if err := pm.StartWithPipes(); err != nil {
+		t.Fatalf("StartWithPipes: %v", err)
+	}
+	t.Cleanup(func() { pm.Stop() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pm.outMu.Lock()
+		seen := pm.outTotal > 0
+		pm.outMu.Unlock()
+		if seen {
+			return pm
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no output from the query server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	pm := startQueryServer(t, "")
+
+	// The stale line printed before the call is discarded.
+	got, err := pm.Query("hello", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if got != "got hello" {
+		t.Errorf("Query = %q, want %q", got, "got hello")
+	}
+
+	got, err = pm.Query("again", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if got != "got again" {
+		t.Errorf("second Query = %q, want %q", got, "got again")
+	}
+}
+
+func TestQueryTerminator(t *testing.T) {
+	pm := startQueryServer(t, "end")
+
+	got, err := pm.Query("multi", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if got != "one\ntwo" {
+		t.Errorf("Query = %q, want %q", got, "one\ntwo")
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	pm := startQueryServer(t, "")
+
+	start := time.Now()
+	if _, err := pm.Query("slow", 200*time.Millisecond); !errors.Is(err, ErrExpectTimeout) {
+		t.Errorf("Query = %v, want ErrExpectTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Query took %v to time out", elapsed)
+	}
+
+	// A timed-out query does not leave anything behind for the next one.
+	got, err := pm.Query("next", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Query after timeout: %v", err)
+	}
+	if got != "got next" {
+		t.Errorf("Query after timeout = %q, want %q", got, "got next")
+	}
+}
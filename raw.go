@@ -0,0 +1,44 @@
+package pipe
+
+import "io"
+
+// PipeHandles holds the caller-owned ends of the standard streams of a
+// process started with StartWithPipesRaw.
+type PipeHandles struct {
+	Stdin  io.WriteCloser
+	Stdout io.ReadCloser
+	Stderr io.ReadCloser
+}
+
+// StartWithPipesRaw starts the process with standard OS pipes but, unlike
+// StartWithPipes, installs no read goroutines: the returned handles give the
+// caller full control over the process's IO. This is an escape hatch for
+// interop with libraries that want plain readers and writers.
+//
+// Output handlers, Expect and LineChannel see no output in this mode, and
+// the caller is responsible for draining and closing Stdout and Stderr. With
+// Config.CombineOutput both streams arrive on Stdout and Stderr is nil.
+// Stop, Wait and the other lifecycle methods still manage the process, and
+// Write still sends to Stdin.
+func (p *ProcessManager) StartWithPipesRaw() (*PipeHandles, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkStartLocked(); err != nil {
+		return nil, err
+	}
+	p.saveAttrLocked()
+
+	stdout, stderr, err := p.startPipes()
+	if err != nil {
+		return nil, err
+	}
+	p.mode = modePipesRaw
+	p.started(0)
+
+	handles := &PipeHandles{Stdin: p.stdinPipe, Stdout: stdout}
+	if stderr != nil {
+		handles.Stderr = stderr
+	}
+	return handles, nil
+}
@@ -0,0 +1,101 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// stderrTailSize is the number of bytes of stderr output kept for
+// ProcessError.Stderr.
+const stderrTailSize = 4096
+
+// stderrDrainTimeout bounds how long the exit of a failed process waits for
+// its stderr to be read, when a child process it left behind holds the
+// stream open.
+const stderrDrainTimeout = 100 * time.Millisecond
+
+// ProcessError reports that a process ran but did not exit successfully.
+// It is returned by Wait and Run, and wraps the underlying *exec.ExitError
+// so errors.As still finds it.
+type ProcessError struct {
+	// Command is the command that was run.
+	Command string
+	// ExitCode is the process's exit code, or -1 if it was terminated by a
+	// signal.
+	ExitCode int
+	// Signaled reports whether the process was terminated by a signal,
+	// including the kill sent by Stop or a cancelled context.
+	Signaled bool
+	// Stderr holds the last few kilobytes written to stderr, to explain
+	// the failure. It is empty in PTY mode and with CombineOutput, where
+	// stderr is not read separately.
+	Stderr []byte
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ProcessError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Command, e.Err)
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// Run runs a command to completion in pipes mode and returns its stdout and
+// stderr merged in the order they were written, like
+// exec.Command(...).CombinedOutput. Its standard input is empty, and the
+// process is killed if ctx is done first.
+//
+// If the command runs but exits unsuccessfully, the output is returned along
+// with a *ProcessError carrying the exit code.
+func Run(ctx context.Context, command string, args ...string) ([]byte, error) {
+	pm := NewWithConfig(Config{
+		Context:       ctx,
+		Command:       command,
+		Args:          args,
+		Stdin:         bytes.NewReader(nil),
+		CombineOutput: true,
+	})
+	defer pm.Stop()
+
+	var out bytes.Buffer
+	pm.CaptureTo(&out)
+
+	if err := pm.StartWithPipes(); err != nil {
+		return nil, err
+	}
+	err := pm.Wait()
+	pm.WaitForOutputDrain()
+	return out.Bytes(), err
+}
+
+// processError wraps the error from waiting for cmd in a *ProcessError if
+// the process exited unsuccessfully. Any stderr output still in flight is
+// given a short time to arrive first.
+func (p *ProcessManager) processError(cmd *exec.Cmd, err error) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	p.awaitStreams(stderrDrainTimeout)
+
+	p.mu.Lock()
+	var tail []byte
+	if p.stderrTail != nil {
+		tail = p.stderrTail.Bytes()
+	}
+	p.mu.Unlock()
+
+	return &ProcessError{
+		Command:  cmd.Args[0],
+		ExitCode: exitErr.ExitCode(),
+		Signaled: !exitErr.Exited(),
+		Stderr:   tail,
+		Err:      err,
+	}
+}
@@ -0,0 +1,455 @@
+package pipe
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrNoScreen is returned by Snapshot when screen tracking was not enabled
+// with Config.TrackScreen.
+var ErrNoScreen = errors.New("screen tracking is not enabled")
+
+// Default screen dimensions used until a window size is set.
+const (
+	defaultScreenRows = 24
+	defaultScreenCols = 80
+)
+
+// Snapshot returns the current contents of the terminal screen as rendered
+// by the process, one line per row joined with "\n" and with trailing blanks
+// removed. Unlike Expect it reflects what a user would see right now, after
+// cursor movement and redraws, which makes it useful for logging the state
+// of a TUI when an automated step fails.
+//
+// It requires Config.TrackScreen; otherwise it returns ErrNoScreen.
+func (p *ProcessManager) Snapshot() (string, error) {
+	if p.screen == nil {
+		return "", ErrNoScreen
+	}
+	return p.screen.String(), nil
+}
+
+// screen is a minimal VT100/xterm screen model. It understands printable
+// text, the common C0 controls, cursor movement, erasing, scroll regions and
+// the alternate screen; colors and other attributes are ignored.
+type screen struct {
+	mu         sync.Mutex
+	rows, cols int
+	grid       [][]rune
+	alt        [][]rune // saved main screen while the alternate one is shown
+	row, col   int
+	wrapNext   bool
+	top, bot   int // scroll region, inclusive
+	savedRow   int
+	savedCol   int
+
+	state   parseState
+	params  []byte
+	pending []byte // incomplete UTF-8 sequence
+}
+
+type parseState int
+
+const (
+	stateText parseState = iota
+	stateEscape
+	stateCharset
+	stateCSI
+	stateOSC
+	stateOSCEscape
+)
+
+func newScreen(rows, cols int) *screen {
+	s := &screen{}
+	s.resize(rows, cols)
+	return s
+}
+
+// Resize changes the screen dimensions, keeping the top-left contents.
+func (s *screen) Resize(rows, cols int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resize(rows, cols)
+}
+
+func (s *screen) resize(rows, cols int) {
+	if rows <= 0 || cols <= 0 {
+		return
+	}
+	s.grid = resizeGrid(s.grid, rows, cols)
+	if s.alt != nil {
+		s.alt = resizeGrid(s.alt, rows, cols)
+	}
+	s.rows, s.cols = rows, cols
+	s.top, s.bot = 0, rows-1
+	s.row = min(s.row, rows-1)
+	s.col = min(s.col, cols-1)
+	s.savedRow = min(s.savedRow, rows-1)
+	s.savedCol = min(s.savedCol, cols-1)
+	s.wrapNext = false
+}
+
+// resizeGrid returns a rows x cols grid holding the top-left contents of old.
+func resizeGrid(old [][]rune, rows, cols int) [][]rune {
+	grid := blankGrid(rows, cols)
+	for r := 0; r < min(rows, len(old)); r++ {
+		copy(grid[r], old[r])
+	}
+	return grid
+}
+
+// restoreCursor moves the cursor to the saved position, kept within the
+// screen in case it has been resized since.
+func (s *screen) restoreCursor() {
+	s.row = clamp(s.savedRow, s.rows)
+	s.col = clamp(s.savedCol, s.cols)
+	s.wrapNext = false
+}
+
+func blankGrid(rows, cols int) [][]rune {
+	grid := make([][]rune, rows)
+	for r := range grid {
+		grid[r] = blankLine(cols)
+	}
+	return grid
+}
+
+func blankLine(cols int) []rune {
+	line := make([]rune, cols)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+// String renders the screen as text.
+func (s *screen) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := make([]string, s.rows)
+	for r, line := range s.grid {
+		lines[r] = strings.TrimRight(string(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Write feeds terminal output into the screen model.
+func (s *screen) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) > 0 {
+		data = append(s.pending, data...)
+		s.pending = nil
+	}
+	for i := 0; i < len(data); {
+		b := data[i]
+		if s.state != stateText || b < utf8.RuneSelf {
+			s.handleByte(b)
+			i++
+			continue
+		}
+		if !utf8.FullRune(data[i:]) {
+			s.pending = append([]byte(nil), data[i:]...)
+			break
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		s.put(r)
+		i += size
+	}
+	return len(data), nil
+}
+
+func (s *screen) handleByte(b byte) {
+	switch s.state {
+	case stateText:
+		s.control(b)
+	case stateEscape:
+		s.state = stateText
+		s.escape(b)
+	case stateCharset:
+		s.state = stateText
+	case stateCSI:
+		if b >= 0x40 && b <= 0x7e {
+			s.state = stateText
+			s.csi(b, string(s.params))
+		} else {
+			s.params = append(s.params, b)
+		}
+	case stateOSC:
+		switch b {
+		case 0x07:
+			s.state = stateText
+		case 0x1b:
+			s.state = stateOSCEscape
+		}
+	case stateOSCEscape:
+		s.state = stateText
+	}
+}
+
+func (s *screen) control(b byte) {
+	switch b {
+	case 0x1b:
+		s.state = stateEscape
+	case '\r':
+		s.col = 0
+		s.wrapNext = false
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\b':
+		if s.col > 0 {
+			s.col--
+		}
+		s.wrapNext = false
+	case '\t':
+		s.col = min((s.col/8+1)*8, s.cols-1)
+	default:
+		if b >= 0x20 && b != 0x7f {
+			s.put(rune(b))
+		}
+	}
+}
+
+func (s *screen) escape(b byte) {
+	switch b {
+	case '[':
+		s.state = stateCSI
+		s.params = s.params[:0]
+	case ']':
+		s.state = stateOSC
+	case '(', ')', '*', '+':
+		s.state = stateCharset
+	case '7':
+		s.savedRow, s.savedCol = s.row, s.col
+	case '8':
+		s.restoreCursor()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.col = 0
+		s.lineFeed()
+	case 'M':
+		if s.row == s.top {
+			s.scrollDown(1)
+		} else if s.row > 0 {
+			s.row--
+		}
+	case 'c':
+		s.grid = blankGrid(s.rows, s.cols)
+		s.row, s.col = 0, 0
+		s.top, s.bot = 0, s.rows-1
+		s.wrapNext = false
+	}
+}
+
+func (s *screen) csi(final byte, params string) {
+	private := strings.HasPrefix(params, "?")
+	args := parseParams(strings.TrimLeft(params, "?>="))
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	s.wrapNext = false
+	switch final {
+	case 'A':
+		s.row = max(s.row-arg(0, 1), 0)
+	case 'B':
+		s.row = min(s.row+arg(0, 1), s.rows-1)
+	case 'C':
+		s.col = min(s.col+arg(0, 1), s.cols-1)
+	case 'D':
+		s.col = max(s.col-arg(0, 1), 0)
+	case 'E':
+		s.row = min(s.row+arg(0, 1), s.rows-1)
+		s.col = 0
+	case 'F':
+		s.row = max(s.row-arg(0, 1), 0)
+		s.col = 0
+	case 'G', '`':
+		s.col = clamp(arg(0, 1)-1, s.cols)
+	case 'd':
+		s.row = clamp(arg(0, 1)-1, s.rows)
+	case 'H', 'f':
+		s.row = clamp(arg(0, 1)-1, s.rows)
+		s.col = clamp(arg(1, 1)-1, s.cols)
+	case 'J':
+		s.eraseDisplay(arg(0, 0))
+	case 'K':
+		s.eraseLine(arg(0, 0))
+	case 'X':
+		s.clear(s.row, s.col, min(s.col+arg(0, 1), s.cols))
+	case 'P':
+		line := s.grid[s.row]
+		n := min(arg(0, 1), s.cols-s.col)
+		copy(line[s.col:], line[s.col+n:])
+		s.clear(s.row, s.cols-n, s.cols)
+	case '@':
+		line := s.grid[s.row]
+		n := min(arg(0, 1), s.cols-s.col)
+		copy(line[s.col+n:], line[s.col:])
+		s.clear(s.row, s.col, s.col+n)
+	case 'L':
+		if s.row >= s.top && s.row <= s.bot {
+			s.scrollRegion(s.row, s.bot, -arg(0, 1))
+		}
+	case 'M':
+		if s.row >= s.top && s.row <= s.bot {
+			s.scrollRegion(s.row, s.bot, arg(0, 1))
+		}
+	case 'S':
+		s.scrollUp(arg(0, 1))
+	case 'T':
+		s.scrollDown(arg(0, 1))
+	case 'r':
+		top, bot := arg(0, 1)-1, arg(1, s.rows)-1
+		if top < bot && bot < s.rows {
+			s.top, s.bot = top, bot
+			s.row, s.col = 0, 0
+		}
+	case 's':
+		s.savedRow, s.savedCol = s.row, s.col
+	case 'u':
+		s.restoreCursor()
+	case 'h', 'l':
+		if private {
+			s.setMode(args, final == 'h')
+		}
+	}
+}
+
+// setMode handles the alternate screen private modes.
+func (s *screen) setMode(args []int, on bool) {
+	for _, mode := range args {
+		if mode != 47 && mode != 1047 && mode != 1049 {
+			continue
+		}
+		switch {
+		case on && s.alt == nil:
+			if mode == 1049 {
+				s.savedRow, s.savedCol = s.row, s.col
+			}
+			s.alt = s.grid
+			s.grid = blankGrid(s.rows, s.cols)
+		case !on && s.alt != nil:
+			s.grid = s.alt
+			s.alt = nil
+			if mode == 1049 {
+				s.restoreCursor()
+			}
+		}
+	}
+}
+
+// put writes a printable rune at the cursor and advances it, wrapping to the
+// next line when the right margin has been reached.
+func (s *screen) put(r rune) {
+	if s.wrapNext {
+		s.col = 0
+		s.lineFeed()
+	}
+	s.grid[s.row][s.col] = r
+	if s.col == s.cols-1 {
+		s.wrapNext = true
+	} else {
+		s.col++
+	}
+}
+
+func (s *screen) lineFeed() {
+	s.wrapNext = false
+	if s.row == s.bot {
+		s.scrollUp(1)
+	} else if s.row < s.rows-1 {
+		s.row++
+	}
+}
+
+func (s *screen) scrollUp(n int) {
+	s.scrollRegion(s.top, s.bot, n)
+}
+
+func (s *screen) scrollDown(n int) {
+	s.scrollRegion(s.top, s.bot, -n)
+}
+
+// scrollRegion shifts rows top..bot by n lines, up when n is positive and
+// down when it is negative, filling the vacated rows with blanks.
+func (s *screen) scrollRegion(top, bot, n int) {
+	height := bot - top + 1
+	if n > 0 {
+		n = min(n, height)
+		copy(s.grid[top:bot+1], s.grid[top+n:bot+1])
+		for r := bot - n + 1; r <= bot; r++ {
+			s.grid[r] = blankLine(s.cols)
+		}
+	} else if n < 0 {
+		n = min(-n, height)
+		copy(s.grid[top+n:bot+1], s.grid[top:bot+1-n])
+		for r := top; r < top+n; r++ {
+			s.grid[r] = blankLine(s.cols)
+		}
+	}
+}
+
+func (s *screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		s.clear(s.row, s.col, s.cols)
+		for r := s.row + 1; r < s.rows; r++ {
+			s.grid[r] = blankLine(s.cols)
+		}
+	case 1:
+		for r := 0; r < s.row; r++ {
+			s.grid[r] = blankLine(s.cols)
+		}
+		s.clear(s.row, 0, s.col+1)
+	case 2, 3:
+		s.grid = blankGrid(s.rows, s.cols)
+	}
+}
+
+func (s *screen) eraseLine(mode int) {
+	switch mode {
+	case 0:
+		s.clear(s.row, s.col, s.cols)
+	case 1:
+		s.clear(s.row, 0, s.col+1)
+	case 2:
+		s.clear(s.row, 0, s.cols)
+	}
+}
+
+// clear blanks columns [from, to) of row.
+func (s *screen) clear(row, from, to int) {
+	line := s.grid[row]
+	for c := from; c < to && c < len(line); c++ {
+		line[c] = ' '
+	}
+}
+
+// parseParams parses the numeric parameters of a CSI sequence. Missing
+// parameters are reported as 0.
+func parseParams(params string) []int {
+	if params == "" {
+		return nil
+	}
+	fields := strings.Split(params, ";")
+	args := make([]int, len(fields))
+	for i, f := range fields {
+		args[i], _ = strconv.Atoi(f)
+	}
+	return args
+}
+
+// clamp limits v to [0, n).
+func clamp(v, n int) int {
+	return max(0, min(v, n-1))
+}
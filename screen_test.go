@@ -0,0 +1,46 @@
+package pipe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScreenResizeAltScreen(t *testing.T) {
+	s := newScreen(24, 80)
+	s.Write([]byte("main\x1b[?1049h"))
+	s.Resize(60, 120)
+	s.Write([]byte("\x1b[?1049l\x1b[50;100Hx"))
+
+	lines := strings.Split(s.String(), "\n")
+	if len(lines) != 60 {
+		t.Fatalf("got %d lines, want 60", len(lines))
+	}
+	if lines[0] != "main" {
+		t.Errorf("line 1 = %q, want %q", lines[0], "main")
+	}
+	if want := strings.Repeat(" ", 99) + "x"; lines[49] != want {
+		t.Errorf("line 50 = %q, want %q", lines[49], want)
+	}
+}
+
+func TestScreenResizeSavedCursor(t *testing.T) {
+	for _, tc := range []struct {
+		name, save, restore string
+	}{
+		{"DECSC", "\x1b7", "\x1b8"},
+		{"SCOSC", "\x1b[s", "\x1b[u"},
+		{"alt screen", "\x1b[?1049h", "\x1b[?1049l"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newScreen(24, 80)
+			s.Write([]byte("\x1b[20;70H" + tc.save))
+			s.Resize(10, 40)
+			s.Write([]byte(tc.restore + "x"))
+
+			lines := strings.Split(s.String(), "\n")
+			if want := strings.Repeat(" ", 39) + "x"; lines[9] != want {
+				t.Errorf("line 10 = %q, want %q", lines[9], want)
+			}
+		})
+	}
+}
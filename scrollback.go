@@ -0,0 +1,95 @@
+package pipe
+
+import (
+	"bytes"
+	"sync"
+)
+
+// ringBuffer retains the most recent bytes written to it, up to a fixed
+// capacity. It is safe for concurrent use.
+type ringBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	start int // index of the oldest byte
+	size  int
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, capacity)}
+}
+
+// Write appends data, discarding the oldest bytes once the buffer is full.
+func (r *ringBuffer) Write(data []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(data)
+	capacity := len(r.buf)
+	if n >= capacity {
+		copy(r.buf, data[n-capacity:])
+		r.start, r.size = 0, capacity
+		return n, nil
+	}
+
+	end := (r.start + r.size) % capacity
+	copied := copy(r.buf[end:], data)
+	copy(r.buf, data[copied:])
+
+	r.size += n
+	if r.size > capacity {
+		r.start = (r.start + r.size - capacity) % capacity
+		r.size = capacity
+	}
+	return n, nil
+}
+
+// Bytes returns a copy of the retained bytes, oldest first.
+func (r *ringBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]byte, r.size)
+	n := copy(out, r.buf[r.start:min(r.start+r.size, len(r.buf))])
+	copy(out[n:], r.buf)
+	return out
+}
+
+// Contains reports whether pattern appears in the output retained by the
+// scrollback buffer. Unlike Expect it does not consume anything, so it can be
+// used to check for a condition ("has an error been printed yet?") without
+// disturbing a later Expect.
+//
+// It requires Config.ScrollbackBytes to be set and only sees the most recent
+// ScrollbackBytes of output; without a scrollback buffer it always returns
+// false.
+func (p *ProcessManager) Contains(pattern string) bool {
+	if p.scrollback == nil {
+		return false
+	}
+	return bytes.Contains(p.scrollback.Bytes(), []byte(pattern))
+}
+
+// LastOutput returns up to the last n bytes of output retained by the
+// scrollback buffer, without consuming them. It requires
+// Config.ScrollbackBytes to be set and returns "" otherwise.
+func (p *ProcessManager) LastOutput(n int) string {
+	if p.scrollback == nil || n <= 0 {
+		return ""
+	}
+	data := p.scrollback.Bytes()
+	if len(data) > n {
+		data = data[len(data)-n:]
+	}
+	return string(data)
+}
+
+// Scrollback returns a copy of all output retained by the scrollback
+// buffer, oldest first, for example to log what actually arrived after an
+// Expect has timed out. It requires Config.ScrollbackBytes to be set and
+// returns nil otherwise.
+func (p *ProcessManager) Scrollback() []byte {
+	if p.scrollback == nil {
+		return nil
+	}
+	return p.scrollback.Bytes()
+}
@@ -0,0 +1,83 @@
+package pipe
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exitCommands maps well-known interactive programs to the command that
+// ends their session.
+var exitCommands = map[string]string{
+	"bash":    "exit",
+	"sh":      "exit",
+	"zsh":     "exit",
+	"dash":    "exit",
+	"ksh":     "exit",
+	"fish":    "exit",
+	"python":  "exit()",
+	"node":    ".exit",
+	"irb":     "exit",
+	"psql":    `\q`,
+	"sqlite3": ".quit",
+	"ghci":    ":quit",
+}
+
+// DefaultExitCommand returns the command that ends an interactive session of
+// the given program, such as "exit" for shells and "exit()" for python.
+// Version suffixes like "python3.12" are ignored. Unknown programs get
+// "exit".
+func DefaultExitCommand(command string) string {
+	name := filepath.Base(command)
+	if cmd, ok := exitCommands[name]; ok {
+		return cmd
+	}
+	if cmd, ok := exitCommands[strings.TrimRight(name, "0123456789.")]; ok {
+		return cmd
+	}
+	return "exit"
+}
+
+// CloseSession ends an interactive session: it sends exitCommand as a line,
+// waits up to timeout for the process to exit on its own, and falls back to
+// StopGracefully (SIGTERM, then kill) if it does not. An empty exitCommand
+// uses DefaultExitCommand for the managed program.
+//
+// It returns the same error Wait would, so a non-zero exit status or the
+// terminating signal is reported.
+func (p *ProcessManager) CloseSession(exitCommand string, timeout time.Duration) error {
+	p.mu.Lock()
+	done := p.done
+	path := p.cmd.Path
+	p.mu.Unlock()
+
+	if done == nil {
+		return p.Stop()
+	}
+	if exitCommand == "" {
+		exitCommand = DefaultExitCommand(path)
+	}
+
+	select {
+	case <-done:
+		return p.Wait()
+	default:
+	}
+
+	if err := p.Writeln(exitCommand); err == nil {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-done:
+			p.Stop()
+			return p.Wait()
+		case <-timer.C:
+		}
+	}
+
+	if _, err := p.StopGracefully(DefaultGracePeriod); err != nil {
+		return err
+	}
+	return p.Wait()
+}
@@ -0,0 +1,166 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// Signal sends sig to the managed process, for example os.Interrupt to
+// interrupt the program running in a shell without tearing the session down.
+// It returns an error if the process has not been started.
+func (p *ProcessManager) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd.Process == nil {
+		return ErrNotStarted
+	}
+	return p.cmd.Process.Signal(sig)
+}
+
+// Interrupt interrupts the program the way Ctrl+C would. In PTY modes it
+// types KeyCtrlC, and the terminal's line discipline turns that into
+// SIGINT for whichever program is in the foreground, such as a command
+// run by a shell rather than the shell itself. A program that has put the
+// terminal into raw mode, as editors and many REPLs do, receives the byte
+// instead and decides for itself what it means. In pipes mode, where there
+// is no terminal, it sends os.Interrupt to the process, and to its whole
+// group unless Config.KillProcessGroup is false; Windows cannot deliver
+// that signal, so there it fails. It returns ErrNotRunning if the process
+// is not running.
+func (p *ProcessManager) Interrupt() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running {
+		return ErrNotRunning
+	}
+	if p.pty != nil {
+		_, err := p.writeLocked([]byte(KeyCtrlC))
+		return err
+	}
+	if p.killGroup {
+		return signalGroup(p.cmd.Process, os.Interrupt)
+	}
+	return p.cmd.Process.Signal(os.Interrupt)
+}
+
+// Pause suspends the process with SIGSTOP, like Ctrl+Z in a shell, until
+// Resume is called. Like Stop, it reaches the whole process group unless
+// Config.KillProcessGroup is false. It returns an error if the process is
+// not running, and on Windows, where suspending is not supported.
+func (p *ProcessManager) Pause() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running {
+		return ErrNotRunning
+	}
+	if err := suspendProcess(p.cmd.Process, p.killGroup); err != nil {
+		return err
+	}
+	p.paused = true
+	return nil
+}
+
+// Resume continues a process suspended with Pause by sending SIGCONT. If a
+// handler is still overrunning Config.PauseWhenSlow, the process stays
+// suspended until that handler returns.
+func (p *ProcessManager) Resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running {
+		return ErrNotRunning
+	}
+	p.paused = false
+	if p.slowHandlers > 0 {
+		return nil
+	}
+	return resumeProcess(p.cmd.Process, p.killGroup)
+}
+
+// IsPaused reports whether the process has been suspended with Pause and not
+// resumed since. A paused process still counts as running for IsRunning.
+func (p *ProcessManager) IsPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// ForwardSignals relays the given signals, when received by the current
+// program, to the managed process instead of acting on them locally. This is
+// what passthrough tools want for signals such as SIGINT and SIGTERM. Like
+// Stop, it reaches the whole process group unless Config.KillProcessGroup is
+// false, so that commands run by a shell receive the signals too.
+//
+// Forwarding lasts until the returned stop function is called or the
+// process exits, whichever comes first; stop restores the previous signal
+// handling and is safe to call more than once. The process must already be
+// started, and only one set of forwarded signals may be active per manager
+// at a time.
+func (p *ProcessManager) ForwardSignals(sigs ...os.Signal) (stop func(), err error) {
+	if len(sigs) == 0 {
+		return nil, fmt.Errorf("no signals to forward")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.done == nil {
+		return nil, ErrNotStarted
+	}
+	if p.forwarding {
+		return nil, fmt.Errorf("signals are already being forwarded")
+	}
+	p.forwarding = true
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	quit := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(quit)
+
+			p.mu.Lock()
+			p.forwarding = false
+			p.mu.Unlock()
+		})
+	}
+
+	go func(done <-chan struct{}) {
+		for {
+			select {
+			case sig := <-ch:
+				p.forwardSignal(sig)
+			case <-done:
+				stop()
+				return
+			case <-quit:
+				return
+			}
+		}
+	}(p.done)
+
+	return stop, nil
+}
+
+// forwardSignal sends sig to the process, and to its group unless
+// Config.KillProcessGroup is false, if it is still running.
+func (p *ProcessManager) forwardSignal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running {
+		return ErrNotRunning
+	}
+	if p.killGroup {
+		return signalGroup(p.cmd.Process, sig)
+	}
+	return p.cmd.Process.Signal(sig)
+}
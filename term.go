@@ -0,0 +1,119 @@
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+// bridgeDrainTimeout bounds how long Bridge waits, after the process has
+// exited, for output still held open by a child process it left behind.
+const bridgeDrainTimeout = 500 * time.Millisecond
+
+// MakeRaw puts the terminal on the current program's standard input into
+// raw mode and returns a function that restores its previous state. In raw
+// mode keystrokes such as Ctrl+C and arrow keys are passed through as bytes
+// instead of being interpreted by the local terminal, so copying stdin to a
+// PTY session gives a fully transparent terminal bridge.
+//
+// Callers must defer restore straight away: a program that exits or panics
+// while the terminal is raw leaves the user's shell unusable until it is
+// reset. It fails if standard input is not a terminal.
+func MakeRaw() (restore func(), err error) {
+	return makeRaw(os.Stdin)
+}
+
+// makeRaw puts the terminal f into raw mode.
+func makeRaw(f *os.File) (restore func(), err error) {
+	fd := int(f.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("make terminal raw: %w", err)
+	}
+	return func() {
+		term.Restore(fd, state)
+	}, nil
+}
+
+// TerminalSize returns the size of the terminal on the current program's
+// standard output, for passing to SetWindowSize or Config.Rows and Cols so
+// that a PTY session matches the user's terminal. It fails if standard
+// output is not a terminal, as when it is piped or redirected in CI.
+func TerminalSize() (rows, cols uint16, err error) {
+	fd := int(os.Stdout.Fd())
+	if !term.IsTerminal(fd) {
+		return 0, 0, fmt.Errorf("get terminal size: standard output is not a terminal")
+	}
+	w, h, err := term.GetSize(fd)
+	if err != nil {
+		return 0, 0, fmt.Errorf("get terminal size: %w", err)
+	}
+	if w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("get terminal size: terminal reports %dx%d", w, h)
+	}
+	return uint16(h), uint16(w), nil
+}
+
+// terminalSize returns the size of the terminal on the current program's
+// standard output, or nil if it is not a terminal.
+func terminalSize() *pty.Winsize {
+	rows, cols, err := TerminalSize()
+	if err != nil {
+		return nil
+	}
+	return &pty.Winsize{Rows: rows, Cols: cols}
+}
+
+// Bridge connects a started process to a terminal: in is forwarded to its
+// input and all of its output is written to out, until the process exits.
+// It is the core of a transparent wrapper such as cmd/pipeit:
+//
+//	pm.StartWithPTY()
+//	err := pm.Bridge(os.Stdin, os.Stdout)
+//
+// When in is a terminal it is put into raw mode for the duration, and when
+// out is the terminal on standard output the PTY follows its size as with
+// InheritWindowSize. Output written before Bridge is called is not copied,
+// so call it straight after starting the process. The output handlers it
+// adds remain in place after it returns.
+//
+// Bridge returns the error from Wait.
+func (p *ProcessManager) Bridge(in io.Reader, out io.Writer) error {
+	p.mu.Lock()
+	started := p.done != nil
+	p.mu.Unlock()
+	if !started {
+		return ErrNotStarted
+	}
+
+	var mu sync.Mutex
+	write := func(data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		out.Write(data)
+	}
+	p.AddOutputHandler(write)
+	p.AddErrorHandler(write)
+
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		if restore, err := makeRaw(f); err == nil {
+			defer restore()
+		}
+	}
+	if f, ok := out.(*os.File); ok && f == os.Stdout && term.IsTerminal(int(f.Fd())) {
+		// Without a PTY, or on Windows, the size is left alone
+		p.InheritWindowSize()
+	}
+
+	stop := p.ForwardStdin(in)
+	defer stop()
+
+	err := p.Wait()
+	p.waitOutputEnd(bridgeDrainTimeout)
+	return err
+}
@@ -0,0 +1,90 @@
+package pipe
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writeLimiter is a token bucket pacing input for Config.WriteRateLimit.
+// The bucket holds a tenth of a second's worth of bytes, so input goes out
+// in small, evenly spaced writes.
+type writeLimiter struct {
+	rate atomic.Int64 // bytes per second; zero means unlimited
+
+	mu     sync.Mutex // held for a whole Write, so writes are not interleaved
+	tokens float64
+	last   time.Time
+}
+
+// take waits until at least one byte may be written at rate bytes per
+// second and returns how many of want may go now. The caller must hold
+// l.mu.
+func (l *writeLimiter) take(ctx context.Context, rate int64, want int) (int, error) {
+	burst := float64(max(rate/10, 1))
+	for {
+		now := time.Now()
+		if l.last.IsZero() {
+			l.tokens = burst
+		} else {
+			l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(rate), burst)
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			n := min(want, int(l.tokens))
+			l.tokens -= float64(n)
+			return n, nil
+		}
+
+		wait := time.Duration((1 - l.tokens) / float64(rate) * float64(time.Second))
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// SetWriteRateLimit changes Config.WriteRateLimit. Zero or a negative rate
+// removes the limit; a Write already being paced picks up the new rate for
+// its remaining bytes.
+func (p *ProcessManager) SetWriteRateLimit(bytesPerSecond int) {
+	p.limiter.rate.Store(int64(max(bytesPerSecond, 0)))
+}
+
+// writeThrottled writes data to the process, pacing it to
+// Config.WriteRateLimit while a limit is set.
+func (p *ProcessManager) writeThrottled(data []byte) (int, error) {
+	if p.limiter.rate.Load() == 0 {
+		return p.write(data)
+	}
+
+	p.limiter.mu.Lock()
+	defer p.limiter.mu.Unlock()
+
+	p.mu.Lock()
+	ctx := p.ctx
+	p.mu.Unlock()
+
+	written := 0
+	for written < len(data) {
+		rate := p.limiter.rate.Load()
+		if rate == 0 {
+			n, err := p.write(data[written:])
+			return written + n, err
+		}
+		n, err := p.limiter.take(ctx, rate, len(data)-written)
+		if err != nil {
+			return written, err
+		}
+		n, err = p.write(data[written : written+n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
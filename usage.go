@@ -0,0 +1,48 @@
+package pipe
+
+import "time"
+
+// ProcResourceUsage is a sample of the resources used by the managed
+// process itself, not counting any children it has started.
+type ProcResourceUsage struct {
+	// UserTime and SystemTime are the CPU time the process has spent in
+	// user and kernel mode.
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// RSS is the process's resident memory in bytes. It is zero once the
+	// process has exited.
+	RSS uint64
+}
+
+// ResourceUsage samples the CPU time and memory of the process, for
+// supervisors that monitor long-running tools. Each call reads the current
+// figures from the operating system; nothing is collected in the
+// background. Once the process has exited it reports its final CPU time,
+// which is available on every platform.
+//
+// Sampling a running process is only supported on Linux, where the figures
+// come from /proc; elsewhere it fails until the process has exited. It
+// returns ErrNotStarted before the process is started.
+func (p *ProcessManager) ResourceUsage() (ProcResourceUsage, error) {
+	p.mu.Lock()
+	running, state := p.running, p.state
+	var pid int
+	if p.cmd.Process != nil {
+		pid = p.cmd.Process.Pid
+	}
+	p.mu.Unlock()
+
+	switch {
+	case running:
+		return sampleUsage(pid)
+	case state != nil:
+		return ProcResourceUsage{
+			UserTime:   state.UserTime(),
+			SystemTime: state.SystemTime(),
+		}, nil
+	case pid == 0:
+		return ProcResourceUsage{}, ErrNotStarted
+	default:
+		return ProcResourceUsage{}, ErrNotRunning
+	}
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package pipe
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// clockTicks is the unit of the CPU times in /proc/<pid>/stat. USER_HZ is
+// 100 on every Linux architecture Go supports.
+const clockTicks = 100
+
+// sampleUsage reads the resource usage of the running process pid from
+// /proc/<pid>/stat.
+func sampleUsage(pid int) (ProcResourceUsage, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ProcResourceUsage{}, fmt.Errorf("read resource usage: %w", err)
+	}
+
+	// The command name in field 2 may itself contain spaces and
+	// parentheses, so count fields from the last ')'.
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return ProcResourceUsage{}, fmt.Errorf("read resource usage: malformed /proc/%d/stat", pid)
+	}
+	fields := bytes.Fields(data[i+1:])
+	// fields[0] is field 3, state; utime, stime and rss are fields 14,
+	// 15 and 24
+	if len(fields) < 22 {
+		return ProcResourceUsage{}, fmt.Errorf("read resource usage: malformed /proc/%d/stat", pid)
+	}
+	var v [3]uint64
+	for j, k := range []int{11, 12, 21} {
+		if v[j], err = strconv.ParseUint(string(fields[k]), 10, 64); err != nil {
+			return ProcResourceUsage{}, fmt.Errorf("read resource usage: %w", err)
+		}
+	}
+	return ProcResourceUsage{
+		UserTime:   time.Duration(v[0]) * time.Second / clockTicks,
+		SystemTime: time.Duration(v[1]) * time.Second / clockTicks,
+		RSS:        v[2] * uint64(os.Getpagesize()),
+	}, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package pipe
+
+import "errors"
+
+var errUsageUnsupported = errors.New("sampling the resource usage of a running process is only supported on linux")
+
+// sampleUsage is only supported on Linux.
+func sampleUsage(pid int) (ProcResourceUsage, error) {
+	return ProcResourceUsage{}, errUsageUnsupported
+}
@@ -0,0 +1,65 @@
+//go:build !windows
+
+package pipe
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// InheritWindowSize sizes the PTY to match the terminal on the current
+// program's standard output, then keeps it in step by resizing the PTY
+// whenever SIGWINCH reports that the terminal has been resized. Full-screen
+// programs such as vim need this to render correctly behind a wrapper.
+//
+// Tracking ends when Stop is called. Calling InheritWindowSize again
+// replaces the previous tracking. It fails if standard output is not a
+// terminal, or with ErrNoPTY if the process is running without a PTY.
+func (p *ProcessManager) InheritWindowSize() error {
+	if err := p.syncWindowSize(); err != nil {
+		return err
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+
+	quit := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(quit)
+		})
+	}
+
+	p.mu.Lock()
+	if p.stopWinch != nil {
+		p.stopWinch()
+	}
+	p.stopWinch = stop
+	p.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-ch:
+				p.syncWindowSize()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// syncWindowSize copies the size of the terminal on standard output to the
+// PTY.
+func (p *ProcessManager) syncWindowSize() error {
+	rows, cols, err := TerminalSize()
+	if err != nil {
+		return err
+	}
+	return p.SetWindowSize(rows, cols)
+}
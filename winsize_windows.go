@@ -0,0 +1,10 @@
+//go:build windows
+
+package pipe
+
+import "errors"
+
+// InheritWindowSize is not supported on Windows.
+func (p *ProcessManager) InheritWindowSize() error {
+	return errors.New("inheriting the window size is not supported on windows")
+}